@@ -2,11 +2,18 @@ package devserver
 
 import (
 	"context"
-	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"hermannm.dev/devlog/log"
 	"hermannm.dev/errclose"
 	"hermannm.dev/wrap/ctxwrap"
@@ -14,93 +21,283 @@ import (
 	"hermannm.dev/personal-website/sitebuilder"
 )
 
+// ServeAndRebuildOnChange builds the site, serves it, and rebuilds it whenever content, templates,
+// static assets or config change. Rebuilds run in-process, so they only take as long as rendering
+// the changed pages. Changes to Go code can't be applied in-process, so the dev server then
+// recompiles itself and restarts.
+//
+// Builds use [sitebuilder.DevPipeline] and skip formatting pages. CSS is generated by Tailwind
+// running in watch mode alongside the dev server, and browsers reload once both pages and CSS are
+// up to date.
+//
+// Runs until the process receives SIGINT or SIGTERM, and then shuts down the server gracefully.
 func ServeAndRebuildOnChange(
 	ctx context.Context,
-	contentPaths sitebuilder.ContentPaths,
-	cssFileName string,
+	config sitebuilder.SiteConfig,
+	configPath string,
 	port string,
 ) (returnedErr error) {
-	buildSite := func() {
-		err := sitebuilder.ExecCommand(
-			ctx,
-			true,
-			"go",
-			"run",
-			"hermannm.dev/personal-website",
-			"-invoked-by-dev-server",
-		)
-		// We only log exec errors here, as actual build errors will be printed by the command
-		if err != nil && !strings.HasPrefix(err.Error(), "go failed") {
-			log.Error(ctx, err, "")
-		}
+	ctx, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	server := newServer(sitebuilder.DevPipeline.OutputDir())
+
+	tailwind, err := startTailwindWatcher(
+		ctx,
+		config.InputCSSFile,
+		sitebuilder.NewDiskOutput(sitebuilder.DevPipeline.OutputDir()),
+		func(err error) {
+			log.Error(ctx, err, "Tailwind stopped, restart the dev server to generate CSS again")
+			server.showBuildError("Tailwind stopped", err)
+		},
+	)
+	if err != nil {
+		return err
 	}
 
-	buildSite()
+	builder := newRebuilder(configPath, config.InputCSSFile, server, tailwind)
+	defer builder.cancel()
+
+	builder.rebuild(ctx, fileChanges{files: nil, lastChange: time.Time{}})
 
-	watcher, err := fsnotify.NewWatcher()
+	watcher, err := newFileWatcher(
+		ctx,
+		[]string{
+			sitebuilder.BaseContentDir,
+			sitebuilder.BaseAssetsDir,
+			sitebuilder.PageTemplatesDir,
+			sitebuilder.ComponentTemplatesDir,
+			"sitebuilder",
+			"devserver",
+		},
+		[]string{"main.go", "go.mod", "go.sum", configPath, config.InputCSSFile},
+	)
 	if err != nil {
-		return ctxwrap.Error(ctx, err, "failed to create file system watcher")
+		return err
 	}
 	defer errclose.Close(watcher, &returnedErr, "file system watcher")
 
+	go watcher.watch(ctx, func(changes fileChanges) { builder.rebuild(ctx, changes) })
+
+	//nolint:exhaustruct
+	httpServer := &http.Server{
+		Addr:              ":" + port,
+		Handler:           server,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serverErr := make(chan error, 1)
 	go func() {
-		var lastEvent fsnotify.Event
-		var timeOfLastBuild time.Time
-
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return // Watcher closed
-				}
-
-				if event == lastEvent && time.Since(timeOfLastBuild) < 100*time.Millisecond {
-					continue
-				}
-
-				buildSite()
-
-				lastEvent = event
-				timeOfLastBuild = time.Now()
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return // Watcher closed
-				}
-
-				log.Error(ctx, err, "File system watcher error")
+		log.Info(ctx, "Serving website...", "url", "http://localhost:"+port)
+		serverErr <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		return ctxwrap.Error(ctx, err, "dev server failed")
+	case <-ctx.Done():
+	}
+
+	log.Info(ctx, "Shutting down dev server...")
+	server.closeEventStreams()
+
+	// Uses a new context, since ctx is already canceled
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return ctxwrap.Error(ctx, err, "failed to shut down dev server")
+	}
+
+	// Tailwind is stopped by ctx being canceled, but we wait for it, so it does not outlive us
+	<-tailwind.exited
+	return nil
+}
+
+// rebuilder runs one build at a time. When files change during a build, that build is canceled,
+// since its output would be outdated anyway.
+type rebuilder struct {
+	configPath string
+	// Changes to this file are picked up by Tailwind directly (see tailwindWatcher). Taken from the
+	// config when the dev server starts, so changing it in the config requires a restart.
+	inputCSSFile string
+	server       *server
+	tailwind     *tailwindWatcher
+	// Cancels the build in progress, and waits for it to stop. Nil if no build has been started.
+	cancelBuild func()
+	lock        sync.Mutex
+}
+
+func newRebuilder(
+	configPath string,
+	inputCSSFile string,
+	server *server,
+	tailwind *tailwindWatcher,
+) *rebuilder {
+	return &rebuilder{
+		configPath:   configPath,
+		inputCSSFile: inputCSSFile,
+		server:       server,
+		tailwind:     tailwind,
+		cancelBuild:  nil,
+		lock:         sync.Mutex{},
+	}
+}
+
+// Cancels any build in progress, then starts a new one in the background.
+func (builder *rebuilder) rebuild(ctx context.Context, changes fileChanges) {
+	builder.lock.Lock()
+	defer builder.lock.Unlock()
+
+	if builder.cancelBuild != nil {
+		builder.cancelBuild()
+	}
+
+	buildCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	builder.cancelBuild = func() {
+		cancel()
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+
+		if changes.requireRecompile() {
+			if err := restartWithRecompiledCode(buildCtx); err != nil && buildCtx.Err() == nil {
+				log.Error(ctx, err, "Failed to apply code changes to dev server")
+				builder.server.showBuildError("Failed to apply code changes to dev server", err)
 			}
+			return
 		}
+
+		if len(changes.files) != 0 {
+			log.Info(ctx, "Files changed, rebuilding website...", "files", changes.files)
+		}
+
+		buildStart := time.Now()
+		result, err := buildSite(buildCtx, builder.configPath)
+		if err != nil {
+			if buildCtx.Err() != nil {
+				log.Info(ctx, "Canceled outdated build")
+				return
+			}
+			log.Error(ctx, err, "Failed to build website")
+			builder.server.showBuildError("Failed to build website", err)
+			return
+		}
+
+		// Reloading before Tailwind has regenerated CSS could show pages with missing styles
+		if cssInputsChanged, ok := builder.lastCSSInputChange(changes, result); ok {
+			builder.tailwind.waitForBuildAfter(buildCtx, cssInputsChanged)
+			if buildCtx.Err() != nil {
+				return
+			}
+		}
+		log.Info(ctx, "Built website", "time", time.Since(buildStart))
+
+		builder.server.reloadBrowsers()
 	}()
+}
 
-	dirsToWatch := []string{
-		"main.go",
-		"sitebuilder",
-		cssFileName,
-		sitebuilder.PageTemplatesDir,
-		sitebuilder.ComponentTemplatesDir,
-		sitebuilder.BaseContentDir,
-		sitebuilder.BaseContentDir + "/icons",
-	}
-	for _, projectDir := range contentPaths.ProjectDirs {
-		dirsToWatch = append(
-			dirsToWatch,
-			fmt.Sprintf("%s/%s", sitebuilder.BaseContentDir, projectDir),
-		)
+// Cancels any build in progress, and waits for it to stop.
+func (builder *rebuilder) cancel() {
+	builder.lock.Lock()
+	defer builder.lock.Unlock()
+
+	if builder.cancelBuild != nil {
+		builder.cancelBuild()
 	}
+}
 
-	for _, dir := range dirsToWatch {
-		if err := watcher.Add(dir); err != nil {
-			return ctxwrap.Errorf(ctx, err, "failed to add '%s' to file system watcher", dir)
+// Returns when the files that Tailwind generates CSS from (the input CSS file and rendered pages)
+// last changed, or false if they did not change in this build.
+func (builder *rebuilder) lastCSSInputChange(
+	changes fileChanges,
+	result sitebuilder.BuildResult,
+) (time.Time, bool) {
+	var lastChange time.Time
+	if slices.Contains(changes.files, filepath.Clean(builder.inputCSSFile)) {
+		lastChange = changes.lastChange
+	}
+
+	output := sitebuilder.NewDiskOutput(sitebuilder.DevPipeline.OutputDir())
+	for _, file := range result.ChangedFiles {
+		if path.Ext(file) != ".html" {
+			continue
+		}
+		if info, err := os.Stat(output.DiskPath(file)); err == nil &&
+			info.ModTime().After(lastChange) {
+			lastChange = info.ModTime()
 		}
 	}
 
-	log.Info(ctx, "Serving website...", "port", port)
-	return sitebuilder.ExecCommand(
+	return lastChange, !lastChange.IsZero()
+}
+
+func buildSite(ctx context.Context, configPath string) (sitebuilder.BuildResult, error) {
+	// Reloads the config on every build, so that changes to it apply without restarting
+	config, err := sitebuilder.LoadSiteConfig(ctx, configPath)
+	if err != nil {
+		return sitebuilder.BuildResult{}, err
+	}
+
+	return sitebuilder.BuildSite(
 		ctx,
-		false,
-		"npx",
-		"live-server",
-		sitebuilder.BaseOutputDir,
-		"--port="+port,
+		config,
+		sitebuilder.NewDiskOutput(sitebuilder.DevPipeline.OutputDir()),
+		sitebuilder.LoadBuildCacheOrEmpty(ctx, sitebuilder.DevPipeline, false),
+		sitebuilder.DevPipeline,
+		sitebuilder.RenderOptions{
+			CollectErrors:          true,
+			UnknownFrontmatterKeys: config.UnknownFrontmatterKeys,
+			// Skips formatting, to keep rebuilds fast
+			HTMLFormat: sitebuilder.HTMLFormatNone,
+		},
 	)
 }
+
+// Tests are not compiled into the dev server, so changes to them don't require a recompile.
+func requiresRecompile(changedFile string) bool {
+	fileName := filepath.Base(changedFile)
+	if strings.HasSuffix(fileName, "_test.go") {
+		return false
+	}
+	return strings.HasSuffix(fileName, ".go") || fileName == "go.mod" || fileName == "go.sum"
+}
+
+// Compiles the current code, and replaces the running dev server process with the new executable
+// (keeping the same command-line arguments). The new process then rebuilds the site, and open
+// browsers reload when they reconnect (see liveReloadScript).
+//
+// Only supported on Unix-like systems, since replacing the process uses the exec system call, which
+// Windows does not have. On Windows, the dev server must be restarted manually after code changes.
+func restartWithRecompiledCode(ctx context.Context) error {
+	if runtime.GOOS == "windows" {
+		return ctxwrap.NewError(
+			ctx,
+			"Go code changed, but the dev server can only restart itself on Unix-like systems, "+
+				"please restart it manually",
+		)
+	}
+
+	log.Info(ctx, "Go code changed, recompiling dev server...")
+
+	executable := filepath.Join(os.TempDir(), "personal-website-dev-server")
+	if err := sitebuilder.ExecCommand(
+		ctx,
+		true,
+		"go",
+		"build",
+		"-o",
+		executable,
+		"hermannm.dev/personal-website",
+	); err != nil {
+		return ctxwrap.Error(ctx, err, "failed to compile dev server")
+	}
+
+	// Only returns if the exec failed
+	if err := syscall.Exec(executable, os.Args, os.Environ()); err != nil {
+		return ctxwrap.Error(ctx, err, "failed to restart dev server, please restart it manually")
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package devserver
+
+import (
+	"errors"
+	"strings"
+
+	"hermannm.dev/personal-website/sitebuilder"
+)
+
+// buildErrorOverlay is sent to browsers when a build fails, to display the error on top of the
+// page (see live_reload.js).
+type buildErrorOverlay struct {
+	Title  string         `json:"title"`
+	Errors []overlayError `json:"errors"`
+}
+
+type overlayError struct {
+	// Blank if the error does not come from a specific content file.
+	File  string        `json:"file"`
+	Lines []overlayLine `json:"lines"`
+}
+
+// One message in the error chain. Wrapped errors are nested one level deeper than the error that
+// wraps them, like when errors are logged to the terminal.
+type overlayLine struct {
+	Text  string `json:"text"`
+	Depth int    `json:"depth"`
+}
+
+func newBuildErrorOverlay(title string, err error) buildErrorOverlay {
+	overlay := buildErrorOverlay{Title: title, Errors: nil}
+
+	// Build errors from content files are split up per file, so each can get its own section
+	var buildErrs sitebuilder.BuildErrors
+	if errors.As(err, &buildErrs) {
+		for _, file := range buildErrs.Files {
+			var lines []overlayLine
+			for _, fileErr := range file.Errors {
+				lines = appendErrorChain(lines, fileErr, 0)
+			}
+
+			fileName := file.ContentFile
+			if fileName == "" {
+				fileName = "build stage '" + strings.Join(file.StageNames, "', '") + "'"
+			}
+			overlay.Errors = append(overlay.Errors, overlayError{File: fileName, Lines: lines})
+		}
+		return overlay
+	}
+
+	overlay.Errors = []overlayError{{File: "", Lines: appendErrorChain(nil, err, 0)}}
+	return overlay
+}
+
+// Implemented by errors from hermannm.dev/wrap (including ctxwrap), to get the message that an
+// error adds without the messages of the errors it wraps.
+type wrappingError interface {
+	WrappingMessage() string
+}
+
+// Flattens the error chain into lines, following the same structure as hermannm.dev/devlog uses
+// when logging errors.
+func appendErrorChain(lines []overlayLine, err error, depth int) []overlayLine {
+	wrapping, ok := err.(wrappingError) //nolint:errorlint // We unwrap the chain ourselves
+	if !ok {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			lines = append(lines, overlayLine{Text: line, Depth: depth})
+		}
+		return lines
+	}
+
+	lines = append(lines, overlayLine{Text: wrapping.WrappingMessage(), Depth: depth})
+
+	//nolint:errorlint // We unwrap the chain ourselves
+	switch wrapped := err.(type) {
+	case interface{ Unwrap() error }:
+		// Wrapped errors are shown at the same depth when there is only one, like in devlog
+		lines = appendErrorChain(lines, wrapped.Unwrap(), depth)
+	case interface{ Unwrap() []error }:
+		for _, wrappedErr := range wrapped.Unwrap() {
+			lines = appendErrorChain(lines, wrappedErr, depth+1)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,171 @@
+package devserver
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"hermannm.dev/devlog/log"
+	"hermannm.dev/wrap/ctxwrap"
+)
+
+// How long the watcher waits after the latest file change before reporting changes. Editors often
+// write several files (or the same file several times) on save, and that should only trigger one
+// build.
+const debounceWindow = 150 * time.Millisecond
+
+// fileWatcher watches directories recursively (including directories created after the watcher
+// started), and single files.
+type fileWatcher struct {
+	watcher *fsnotify.Watcher
+	// Watched recursively.
+	dirs []string
+	// Watched through their parent directories, since editors often save files by replacing them,
+	// which would end a watch on the file itself. Other files in those parent directories are
+	// ignored.
+	files []string
+}
+
+// fileChanges is a batch of file changes, reported by [fileWatcher.watch] once files have stopped
+// changing.
+type fileChanges struct {
+	files []string
+	// When the last of the files changed.
+	lastChange time.Time
+}
+
+func newFileWatcher(ctx context.Context, dirs []string, files []string) (*fileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, ctxwrap.Error(ctx, err, "failed to create file system watcher")
+	}
+
+	fileWatcher := &fileWatcher{watcher: watcher, dirs: nil, files: nil}
+	for _, dir := range dirs {
+		fileWatcher.dirs = append(fileWatcher.dirs, filepath.Clean(dir))
+		if err := fileWatcher.addDirRecursively(dir); err != nil {
+			_ = watcher.Close()
+			return nil, ctxwrap.Errorf(ctx, err, "failed to watch directory '%s'", dir)
+		}
+	}
+	for _, file := range files {
+		fileWatcher.files = append(fileWatcher.files, filepath.Clean(file))
+		if err := watcher.Add(filepath.Dir(file)); err != nil {
+			_ = watcher.Close()
+			return nil, ctxwrap.Errorf(ctx, err, "failed to watch file '%s'", file)
+		}
+	}
+
+	return fileWatcher, nil
+}
+
+func (fileWatcher *fileWatcher) Close() error {
+	return fileWatcher.watcher.Close()
+}
+
+// Calls onChange with all files that changed, once no file has changed for [debounceWindow]. Runs
+// until ctx is canceled or the watcher is closed.
+func (fileWatcher *fileWatcher) watch(ctx context.Context, onChange func(fileChanges)) {
+	var pending fileChanges
+	debounce := time.NewTimer(debounceWindow)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	for {
+		select {
+		case event, ok := <-fileWatcher.watcher.Events:
+			if !ok {
+				return // Watcher closed
+			}
+
+			if !fileWatcher.isWatched(event.Name) {
+				continue
+			}
+			// Chmod events come from e.g. indexers and virus scanners, and don't change content
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := fileWatcher.addDirRecursively(event.Name); err != nil {
+						log.Error(ctx, err, "Failed to watch new directory", "dir", event.Name)
+					}
+				}
+			}
+
+			changedFile := filepath.Clean(event.Name)
+			if !slices.Contains(pending.files, changedFile) {
+				pending.files = append(pending.files, changedFile)
+			}
+			pending.lastChange = time.Now()
+			debounce.Reset(debounceWindow)
+		case <-debounce.C:
+			onChange(pending)
+			pending = fileChanges{files: nil, lastChange: time.Time{}}
+		case err, ok := <-fileWatcher.watcher.Errors:
+			if !ok {
+				return // Watcher closed
+			}
+
+			log.Error(ctx, err, "File system watcher error")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (fileWatcher *fileWatcher) addDirRecursively(root string) error {
+	return filepath.WalkDir(
+		root,
+		func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() {
+				return nil
+			}
+			if path != root && isIgnoredFile(path) {
+				return filepath.SkipDir
+			}
+
+			return fileWatcher.watcher.Add(path)
+		},
+	)
+}
+
+func (fileWatcher *fileWatcher) isWatched(path string) bool {
+	path = filepath.Clean(path)
+	if isIgnoredFile(path) {
+		return false
+	}
+
+	if slices.Contains(fileWatcher.files, path) {
+		return true
+	}
+	for _, dir := range fileWatcher.dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Hidden files and editor backups (such as Vim's .swp files and Emacs' ~ files) change while
+// editing, but don't affect the build. Neither do test fixtures in testdata directories (such as
+// sitebuilder/testdata), which Go ignores when compiling.
+func isIgnoredFile(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") || name == "testdata"
+}
+
+// Whether Go code changed, which requires the dev server to recompile itself (see
+// restartWithRecompiledCode).
+func (changes fileChanges) requireRecompile() bool {
+	return slices.ContainsFunc(changes.files, requiresRecompile)
+}
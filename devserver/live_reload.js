@@ -0,0 +1,77 @@
+// Injected into every HTML page served by the dev server (see liveReloadScript in server.go).
+(() => {
+  const events = new EventSource("/_devserver/live-reload");
+
+  // The browser automatically reconnects if the dev server restarts (e.g. after recompiling). The
+  // restarted server has rebuilt the site, so we reload if the server ID has changed.
+  let serverId;
+  events.addEventListener("connected", (event) => {
+    if (serverId !== undefined && serverId !== event.data) {
+      location.reload();
+    }
+    serverId = event.data;
+  });
+
+  events.addEventListener("reload", () => location.reload());
+
+  events.addEventListener("build-error", (event) => {
+    showBuildError(JSON.parse(event.data));
+  });
+
+  const overlayId = "devserver-error-overlay";
+
+  /**
+   * @param {{
+   *   title: string,
+   *   errors: { file: string, lines: { text: string, depth: number }[] }[],
+   * }} buildError
+   */
+  function showBuildError(buildError) {
+    document.getElementById(overlayId)?.remove();
+
+    const overlay = document.createElement("div");
+    overlay.id = overlayId;
+    overlay.style.cssText = `
+      position: fixed; inset: 0; z-index: 2147483647; overflow: auto; padding: 2rem;
+      background: rgba(29, 32, 33, 0.96); color: #ebdbb2;
+      font: 14px/1.5 ui-monospace, SFMono-Regular, Menlo, Consolas, monospace;
+    `;
+
+    const header = document.createElement("div");
+    header.style.cssText = "display: flex; justify-content: space-between; gap: 1rem;";
+    const title = document.createElement("h1");
+    title.textContent = buildError.title;
+    title.style.cssText = "margin: 0 0 1.5rem; font-size: 1.25rem; color: #fb4934;";
+    const closeButton = document.createElement("button");
+    closeButton.textContent = "×";
+    closeButton.title = "Hide until the next build";
+    closeButton.style.cssText =
+      "align-self: start; border: none; background: none; color: inherit; font-size: 1.5rem;";
+    closeButton.addEventListener("click", () => overlay.remove());
+    header.append(title, closeButton);
+    overlay.append(header);
+
+    for (const error of buildError.errors) {
+      const section = document.createElement("section");
+      section.style.cssText = "margin-bottom: 1.5rem;";
+
+      if (error.file) {
+        const file = document.createElement("div");
+        file.textContent = error.file;
+        file.style.cssText = "color: #fabd2f; font-weight: bold; margin-bottom: 0.25rem;";
+        section.append(file);
+      }
+
+      for (const line of error.lines) {
+        const lineElement = document.createElement("div");
+        lineElement.textContent = (line.depth > 0 ? "- " : "") + line.text;
+        lineElement.style.cssText = `padding-left: ${line.depth * 1.5}rem; white-space: pre-wrap;`;
+        section.append(lineElement);
+      }
+
+      overlay.append(section);
+    }
+
+    document.body.append(overlay);
+  }
+})();
@@ -0,0 +1,219 @@
+package devserver
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hermannm.dev/personal-website/sitebuilder"
+)
+
+// Path of the Server-Sent Events stream that the live reload script listens to. Prefixed with an
+// underscore, so it does not collide with any page path. Must match the path in live_reload.js.
+const liveReloadPath = "/_devserver/live-reload"
+
+//go:embed live_reload.js
+var liveReloadJS string
+
+// Injected before the closing </body> tag of every HTML page served by the dev server.
+var liveReloadScript = "<script>\n" + liveReloadJS + "</script>\n"
+
+// server serves the build output over HTTP with the same URL rules as GitHub Pages (see
+// [sitebuilder.ResolveGitHubPagesPath]), and pushes live reload events to connected browsers.
+type server struct {
+	// Unique for each run of the dev server, see liveReloadScript.
+	id     string
+	output fs.FS
+	// Each connected browser has a channel that events are sent on.
+	clients map[chan serverEvent]struct{}
+	// Set while the latest build has failed, so that browsers connecting after the failure (e.g.
+	// when navigating to another page) also show the error.
+	buildError *serverEvent
+	lock       sync.Mutex
+	// Closed when the dev server shuts down, which ends the live reload event streams (otherwise,
+	// the HTTP server would wait for them to end before shutting down).
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+}
+
+type serverEvent struct {
+	name string
+	data string
+}
+
+func newServer(outputDir string) *server {
+	return &server{
+		id:           strconv.FormatInt(time.Now().UnixNano(), 10),
+		output:       os.DirFS(outputDir),
+		clients:      make(map[chan serverEvent]struct{}),
+		buildError:   nil,
+		lock:         sync.Mutex{},
+		shutdown:     make(chan struct{}),
+		shutdownOnce: sync.Once{},
+	}
+}
+
+func (server *server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path == liveReloadPath {
+		server.serveLiveReloadEvents(writer, request)
+		return
+	}
+
+	// Pages change on every rebuild, so the browser should never use a cached version
+	writer.Header().Set("Cache-Control", "no-store")
+
+	response := sitebuilder.ResolveGitHubPagesPath(server.output, request.URL.Path)
+	if response.RedirectPath != "" {
+		http.Redirect(writer, request, response.RedirectPath, response.StatusCode)
+		return
+	}
+	if response.FilePath == "" {
+		http.Error(writer, "404 page not found", response.StatusCode)
+		return
+	}
+
+	content, err := fs.ReadFile(server.output, response.FilePath)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if path.Ext(response.FilePath) == ".html" {
+		content = injectLiveReloadScript(content)
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(response.FilePath))
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+	writer.Header().Set("Content-Type", contentType)
+	writer.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	writer.WriteHeader(response.StatusCode)
+	if request.Method != http.MethodHead {
+		_, _ = writer.Write(content)
+	}
+}
+
+func injectLiveReloadScript(html []byte) []byte {
+	bodyEnd := bytes.LastIndex(html, []byte("</body>"))
+	if bodyEnd == -1 {
+		return append(html, liveReloadScript...)
+	}
+
+	injected := make([]byte, 0, len(html)+len(liveReloadScript))
+	injected = append(injected, html[:bodyEnd]...)
+	injected = append(injected, liveReloadScript...)
+	injected = append(injected, html[bodyEnd:]...)
+	return injected
+}
+
+func (server *server) serveLiveReloadEvents(writer http.ResponseWriter, request *http.Request) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(http.StatusOK)
+	if err := writeEvent(writer, serverEvent{name: "connected", data: server.id}); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	events := make(chan serverEvent, 1)
+	server.lock.Lock()
+	server.clients[events] = struct{}{}
+	if server.buildError != nil {
+		events <- *server.buildError
+	}
+	server.lock.Unlock()
+	defer func() {
+		server.lock.Lock()
+		delete(server.clients, events)
+		server.lock.Unlock()
+	}()
+
+	for {
+		select {
+		case event := <-events:
+			if err := writeEvent(writer, event); err != nil {
+				return // Browser disconnected
+			}
+			flusher.Flush()
+		case <-request.Context().Done():
+			return
+		case <-server.shutdown:
+			return
+		}
+	}
+}
+
+// Ends all live reload event streams. Browsers then try to reconnect, and reload once the dev
+// server is started again (see liveReloadScript).
+func (server *server) closeEventStreams() {
+	server.shutdownOnce.Do(func() { close(server.shutdown) })
+}
+
+func writeEvent(writer http.ResponseWriter, event serverEvent) error {
+	var message strings.Builder
+	message.WriteString("event: " + event.name + "\n")
+	// Multi-line data must be split into one data field per line
+	for line := range strings.SplitSeq(event.data, "\n") {
+		message.WriteString("data: " + line + "\n")
+	}
+	message.WriteString("\n")
+
+	_, err := fmt.Fprint(writer, message.String())
+	return err
+}
+
+// Sends the event to all connected browsers. If a browser has not yet received the previous event,
+// that event is replaced, since an older event is never more relevant than a newer one.
+func (server *server) broadcast(event serverEvent) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	for client := range server.clients {
+		select {
+		case <-client:
+		default:
+		}
+		client <- event
+	}
+}
+
+// Reloads open pages after a successful build, which also clears any error overlay.
+func (server *server) reloadBrowsers() {
+	server.lock.Lock()
+	server.buildError = nil
+	server.lock.Unlock()
+
+	server.broadcast(serverEvent{name: "reload", data: ""})
+}
+
+// Shows the error as an overlay on open pages, until the next successful build.
+func (server *server) showBuildError(title string, err error) {
+	overlayJSON, jsonErr := json.Marshal(newBuildErrorOverlay(title, err))
+	if jsonErr != nil {
+		// Should never happen, since the overlay only contains strings and ints
+		overlayJSON = []byte(`{"title":"Failed to serialize build error","errors":[]}`)
+	}
+	event := serverEvent{name: "build-error", data: string(overlayJSON)}
+
+	server.lock.Lock()
+	server.buildError = &event
+	server.lock.Unlock()
+
+	server.broadcast(event)
+}
@@ -0,0 +1,162 @@
+package devserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"hermannm.dev/devlog/log"
+	"hermannm.dev/wrap/ctxwrap"
+
+	"hermannm.dev/personal-website/sitebuilder"
+)
+
+// How long the dev server waits for Tailwind to regenerate CSS after a build that changed its
+// inputs, before reloading browsers anyway (in case Tailwind missed the change).
+const cssSettleTimeout = time.Second
+
+// tailwindWatcher runs Tailwind in watch mode for the lifetime of the dev server, regenerating CSS
+// whenever the input CSS file or the rendered pages change. This is much faster than starting
+// Tailwind on every rebuild, since it then only scans the changed files.
+type tailwindWatcher struct {
+	// When Tailwind last finished generating CSS.
+	lastBuild time.Time
+	// Closed and replaced each time Tailwind finishes generating CSS.
+	built chan struct{}
+	// Closed when the Tailwind process exits.
+	exited chan struct{}
+	lock   sync.Mutex
+}
+
+// Starts Tailwind in watch mode, writing to the same output path as
+// [sitebuilder.GenerateTailwindCSS] (but without minification). Calls onExit if Tailwind stops
+// before ctx is canceled.
+func startTailwindWatcher(
+	ctx context.Context,
+	inputCSSFile string,
+	output sitebuilder.DiskOutput,
+	onExit func(error),
+) (*tailwindWatcher, error) {
+	if err := output.MkdirAll("."); err != nil {
+		return nil, ctxwrap.Error(ctx, err, "failed to create output directory")
+	}
+
+	command := exec.CommandContext(
+		ctx,
+		"npx",
+		"@tailwindcss/cli",
+		"-i",
+		inputCSSFile,
+		"-o",
+		output.DiskPath(sitebuilder.CSSOutputPath(inputCSSFile)),
+		"--watch",
+	)
+	command.Stdout = os.Stdout
+
+	// Tailwind stops watching once its input is closed. We use this to stop it when ctx is
+	// canceled, since killing npx would leave Tailwind itself running.
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		return nil, ctxwrap.Error(ctx, err, "failed to get pipe to Tailwind's input")
+	}
+	command.Cancel = stdin.Close
+	command.WaitDelay = 5 * time.Second
+
+	stderr, err := command.StderrPipe()
+	if err != nil {
+		return nil, ctxwrap.Error(ctx, err, "failed to get pipe to Tailwind's error output")
+	}
+
+	if err := command.Start(); err != nil {
+		return nil, ctxwrap.Error(ctx, err, "failed to start Tailwind in watch mode")
+	}
+
+	tailwind := &tailwindWatcher{
+		lastBuild: time.Time{},
+		built:     make(chan struct{}),
+		exited:    make(chan struct{}),
+		lock:      sync.Mutex{},
+	}
+
+	go func() {
+		errScanner := bufio.NewScanner(stderr)
+		var lastErrs []string
+		for errScanner.Scan() {
+			line := errScanner.Text()
+			// Tailwind reports each finished build on its error output, e.g. "Done in 35ms"
+			if strings.HasPrefix(line, "Done in") {
+				tailwind.markBuilt()
+				continue
+			}
+
+			_, _ = fmt.Fprintln(os.Stderr, line)
+			lastErrs = append(lastErrs, line)
+			if len(lastErrs) > 20 {
+				lastErrs = lastErrs[1:]
+			}
+		}
+
+		err := command.Wait()
+		close(tailwind.exited)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			err = errors.New("tailwind --watch stopped unexpectedly")
+		} else {
+			err = fmt.Errorf("tailwind --watch failed: %w", err)
+		}
+		if len(lastErrs) != 0 {
+			err = ctxwrap.Error(ctx, errors.New(strings.Join(lastErrs, "\n")), err.Error())
+		}
+		onExit(err)
+	}()
+
+	return tailwind, nil
+}
+
+func (tailwind *tailwindWatcher) markBuilt() {
+	tailwind.lock.Lock()
+	defer tailwind.lock.Unlock()
+
+	tailwind.lastBuild = time.Now()
+	close(tailwind.built)
+	tailwind.built = make(chan struct{})
+}
+
+// Waits until Tailwind has finished generating CSS after the given time (or until
+// [cssSettleTimeout] has passed). Returns false if it stopped waiting for any other reason than
+// Tailwind finishing.
+func (tailwind *tailwindWatcher) waitForBuildAfter(ctx context.Context, after time.Time) bool {
+	timeout := time.NewTimer(cssSettleTimeout)
+	defer timeout.Stop()
+
+	for {
+		tailwind.lock.Lock()
+		lastBuild := tailwind.lastBuild
+		built := tailwind.built
+		tailwind.lock.Unlock()
+
+		if lastBuild.After(after) {
+			return true
+		}
+
+		select {
+		case <-built:
+		case <-timeout.C:
+			log.Debug(ctx, "Timed out waiting for Tailwind to regenerate CSS")
+			return false
+		case <-tailwind.exited:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
@@ -10,6 +10,7 @@ require (
 	github.com/go-playground/validator/v10 v10.30.1
 	github.com/yuin/goldmark v1.7.16
 	golang.org/x/sync v0.19.0
+	gopkg.in/yaml.v3 v3.0.1
 	hermannm.dev/devlog v0.6.0
 	hermannm.dev/errclose v0.1.1
 	hermannm.dev/wrap v0.4.0
@@ -0,0 +1,400 @@
+package sitebuilder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"hermannm.dev/wrap"
+	"hermannm.dev/wrap/ctxwrap"
+)
+
+// Assets with these extensions get a hash of their content in their output file name (see
+// [AssetManifest]). Other files keep their names, such as favicon.ico (which browsers request from
+// a fixed path) and font licenses.
+var fingerprintedExtensions = []string{
+	".css",
+	".woff",
+	".woff2",
+	".png",
+	".jpg",
+	".jpeg",
+	".gif",
+	".webp",
+	".avif",
+	".svg",
+}
+
+// Number of hex characters from the content hash that are included in fingerprinted file names.
+const fingerprintLength = 8
+
+// AssetManifest maps the paths that content and templates use for static assets (e.g.
+// "/img/logos/gadd.png" for the file at static/img/logos/gadd.png) to their paths in the output.
+//
+// With fingerprinting enabled, output file names include a hash of the file content (e.g.
+// "/img/logos/gadd.3f2a1c9e.png"). Changing a file then changes its URL, so the site can be served
+// with long-term caching without browsers ever using stale files.
+//
+// The CSS generated by Tailwind is included as well. Since Tailwind generates CSS from the
+// rendered pages, the CSS can only be fingerprinted after rendering (see
+// [AssetManifest.FingerprintCSS]). Until then, it resolves to its plain name.
+//
+// Files in page bundles are added when their page is parsed (see [PageBundle]).
+type AssetManifest struct {
+	// Keyed by the path used in content and templates, or by the source path for files in page
+	// bundles.
+	assets      map[string]assetFile
+	fingerprint bool
+	// Path of the generated CSS file in assets.
+	cssPath string
+	// Page bundles are added while other pages are rendered.
+	lock sync.Mutex
+}
+
+type assetFile struct {
+	// Path of the checked-in file. Blank for the generated CSS file.
+	sourcePath string
+	// Output path before fingerprinting, starting with a slash.
+	plainPath   string
+	outputPath  string
+	contentHash string
+	// Set for images that the [ImagePipeline] has written to outputPath with metadata stripped,
+	// which [PageRenderer.CopyAssets] then skips.
+	processed bool
+}
+
+// LoadAssetManifest hashes all files in [BaseAssetsDir]. If fingerprint is false, assets keep their
+// names in the output (which the dev server uses, since it has no need for cache busting).
+func LoadAssetManifest(
+	ctx context.Context,
+	inputCSSFile string,
+	fingerprint bool,
+) (*AssetManifest, error) {
+	cssPath := "/" + CSSOutputPath(inputCSSFile)
+	manifest := &AssetManifest{
+		assets: map[string]assetFile{
+			cssPath: {
+				sourcePath:  "",
+				plainPath:   cssPath,
+				outputPath:  cssPath,
+				contentHash: "",
+				processed:   false,
+			},
+		},
+		fingerprint: fingerprint,
+		cssPath:     cssPath,
+		lock:        sync.Mutex{},
+	}
+
+	_, err := manifest.addFiles(
+		BaseAssetsDir,
+		"/",
+		func(assetPath string) (string, bool) { return "/" + assetPath, true },
+	)
+	if err != nil {
+		return nil, ctxwrap.Errorf(
+			ctx,
+			err,
+			"failed to read static assets from '%s'",
+			BaseAssetsDir,
+		)
+	}
+
+	return manifest, nil
+}
+
+// Hashes the files in the given directory, and adds them to the manifest with output paths under
+// outputDir. getKey returns the key for a file given its path relative to the directory, or false
+// if the file should not be added. Returns the source paths of the added files.
+func (manifest *AssetManifest) addFiles(
+	dir string,
+	outputDir string,
+	getKey func(relativePath string) (key string, include bool),
+) (sourcePaths []string, err error) {
+	files := os.DirFS(dir)
+	err = fs.WalkDir(
+		files,
+		".",
+		func(relativePath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				return nil
+			}
+			key, include := getKey(relativePath)
+			if !include {
+				return nil
+			}
+
+			content, err := fs.ReadFile(files, relativePath)
+			if err != nil {
+				return wrap.Errorf(err, "failed to read asset '%s'", path.Join(dir, relativePath))
+			}
+			contentHash := sha256.Sum256(content)
+
+			asset := assetFile{
+				sourcePath:  path.Join(dir, relativePath),
+				plainPath:   path.Join(outputDir, relativePath),
+				outputPath:  path.Join(outputDir, relativePath),
+				contentHash: hex.EncodeToString(contentHash[:]),
+				processed:   false,
+			}
+			if manifest.fingerprint {
+				asset.outputPath = fingerprintedPath(asset.outputPath, asset.contentHash)
+			}
+
+			manifest.lock.Lock()
+			manifest.assets[key] = asset
+			manifest.lock.Unlock()
+
+			sourcePaths = append(sourcePaths, asset.sourcePath)
+			return nil
+		},
+	)
+	return sourcePaths, err
+}
+
+// Path returns the output path for the asset at the given path, which must start with a slash
+// (e.g. "/img/logos/gadd.png"), or be a file in a page bundle (see [PageBundle.Resolve]). Returns
+// an error if there is no such asset. Exposed to templates as the "asset" function.
+func (manifest *AssetManifest) Path(assetPath string) (string, error) {
+	manifest.lock.Lock()
+	asset, ok := manifest.assets[assetPath]
+	manifest.lock.Unlock()
+	if !ok {
+		return "", missingAssetError(assetPath)
+	}
+	return asset.outputPath, nil
+}
+
+// Returns the checked-in asset file at the given path (as passed to [AssetManifest.Path]).
+func (manifest *AssetManifest) source(assetPath string) (assetFile, error) {
+	manifest.lock.Lock()
+	asset, ok := manifest.assets[assetPath]
+	manifest.lock.Unlock()
+	if !ok || asset.sourcePath == "" {
+		return assetFile{}, missingAssetError(assetPath)
+	}
+	return asset, nil
+}
+
+// Returns a copy of the assets in the manifest, keyed like the manifest itself.
+func (manifest *AssetManifest) files() map[string]assetFile {
+	manifest.lock.Lock()
+	defer manifest.lock.Unlock()
+	return maps.Clone(manifest.assets)
+}
+
+// Marks the asset at the given path (as passed to [AssetManifest.Path]) as written by the
+// [ImagePipeline], so that it is not copied as-is.
+func (manifest *AssetManifest) markProcessed(assetPath string) {
+	manifest.lock.Lock()
+	defer manifest.lock.Unlock()
+	if asset, ok := manifest.assets[assetPath]; ok {
+		asset.processed = true
+		manifest.assets[assetPath] = asset
+	}
+}
+
+func missingAssetError(assetPath string) error {
+	if strings.HasPrefix(assetPath, "/") {
+		return fmt.Errorf("no asset found at '%s' in '%s'", assetPath, BaseAssetsDir)
+	} else {
+		return fmt.Errorf("no file found at '%s' in page bundle", assetPath)
+	}
+}
+
+// Returns the given path with the start of the content hash inserted before the file extension,
+// or the path unchanged if its extension is not in fingerprintedExtensions.
+func fingerprintedPath(filePath string, contentHash string) string {
+	extension := path.Ext(filePath)
+	if !slices.Contains(fingerprintedExtensions, strings.ToLower(extension)) {
+		return filePath
+	}
+	return fmt.Sprintf(
+		"%s.%s%s",
+		strings.TrimSuffix(filePath, extension),
+		contentHash[:fingerprintLength],
+		extension,
+	)
+}
+
+// AssetsStage returns a build stage that copies static assets and files in page bundles. It waits
+// for the given pages to be parsed, since page bundles are added to the [AssetManifest] while
+// parsing, and images processed by the [ImagePipeline] must not be copied with their metadata.
+func (renderer *PageRenderer) AssetsStage(pages []BuildOutput[Page]) BuildStage {
+	return BuildStage{
+		Name:        "copy assets",
+		ContentFile: "",
+		Inputs:      OutputKeys(pages...),
+		Outputs:     nil,
+		Run: func(ctx context.Context, build BuildState) error {
+			return renderer.CopyAssets(ctx)
+		},
+	}
+}
+
+// CopyAssets copies the files in [BaseAssetsDir] and page bundles to their output paths in the
+// [AssetManifest]. Files that are unchanged since the previous build are not copied again. Images
+// that the [ImagePipeline] has already written without metadata are skipped.
+func (renderer *PageRenderer) CopyAssets(ctx context.Context) error {
+	assets := renderer.assets.files()
+	for _, assetPath := range sortedKeys(assets) {
+		asset := assets[assetPath]
+		if asset.sourcePath == "" {
+			continue // Generated CSS
+		}
+		if asset.processed {
+			continue
+		}
+
+		outputPath := strings.TrimPrefix(asset.outputPath, "/")
+		if renderer.cache.isFresh(renderer.output, outputPath, asset.contentHash) {
+			renderer.cache.recordOutput(outputPath, asset.contentHash, false)
+			continue
+		}
+
+		content, err := os.ReadFile(asset.sourcePath)
+		if err != nil {
+			return ctxwrap.Errorf(ctx, err, "failed to read asset '%s'", asset.sourcePath)
+		}
+
+		if dir := path.Dir(outputPath); dir != "." {
+			if err := renderer.output.MkdirAll(dir); err != nil {
+				return ctxwrap.Error(ctx, err, "failed to copy assets")
+			}
+		}
+		if err := renderer.output.WriteFile(outputPath, content); err != nil {
+			return ctxwrap.Error(ctx, err, "failed to copy assets")
+		}
+
+		renderer.cache.recordOutput(outputPath, asset.contentHash, true)
+	}
+
+	return nil
+}
+
+// Matches url() references to absolute paths in CSS, such as url("/fonts/font.woff2"). Tailwind
+// may remove the quotes when minifying.
+var cssURLPattern = regexp.MustCompile(`url\(\s*(['"]?)(/[^'")\s]*)['"]?\s*\)`)
+
+// FingerprintCSS writes a fingerprinted copy of the CSS generated by Tailwind (see
+// [AssetManifest]), with url() references to other assets (such as fonts) pointed to their
+// fingerprinted paths. Then it updates the stylesheet links in all pages produced in this build,
+// since pages are rendered before CSS is generated. Pages that were unchanged from the previous
+// build link to the CSS of that build, so those links are updated as well.
+//
+// Does nothing if fingerprinting is disabled. Must be called after CSS has been generated, and
+// after all pages have been rendered.
+func (manifest *AssetManifest) FingerprintCSS(
+	ctx context.Context,
+	output OutputFS,
+	cache *BuildCache,
+) error {
+	if !manifest.fingerprint {
+		return nil
+	}
+
+	plainPath := strings.TrimPrefix(manifest.cssPath, "/")
+	css, err := fs.ReadFile(output, plainPath)
+	if err != nil {
+		return ctxwrap.Errorf(ctx, err, "failed to read generated CSS file '%s'", plainPath)
+	}
+
+	var urlErrs []error
+	css = cssURLPattern.ReplaceAllFunc(
+		css,
+		func(match []byte) []byte {
+			groups := cssURLPattern.FindSubmatch(match)
+			assetPath, err := manifest.Path(string(groups[2]))
+			if err != nil {
+				urlErrs = append(urlErrs, err)
+				return match
+			}
+			return fmt.Appendf(nil, "url(%s%s%s)", groups[1], assetPath, groups[1])
+		},
+	)
+	if len(urlErrs) != 0 {
+		return ctxwrap.Errorsf(
+			ctx,
+			urlErrs,
+			"generated CSS file '%s' references missing assets",
+			plainPath,
+		)
+	}
+
+	cssHash := sha256.Sum256(css)
+	contentHash := hex.EncodeToString(cssHash[:])
+	outputPath := fingerprintedPath(plainPath, contentHash)
+	if cache.isFresh(output, outputPath, contentHash) {
+		cache.recordOutput(outputPath, contentHash, false)
+	} else {
+		if err := output.WriteFile(outputPath, css); err != nil {
+			return ctxwrap.Error(ctx, err, "failed to write fingerprinted CSS file")
+		}
+		cache.recordOutput(outputPath, contentHash, true)
+	}
+
+	manifest.lock.Lock()
+	manifest.assets[manifest.cssPath] = assetFile{
+		sourcePath:  "",
+		plainPath:   manifest.cssPath,
+		outputPath:  "/" + outputPath,
+		contentHash: contentHash,
+		processed:   false,
+	}
+	manifest.lock.Unlock()
+
+	if err := manifest.linkFingerprintedCSS(output, cache); err != nil {
+		return ctxwrap.Error(ctx, err, "failed to link pages to fingerprinted CSS")
+	}
+	return nil
+}
+
+func (manifest *AssetManifest) linkFingerprintedCSS(output OutputFS, cache *BuildCache) error {
+	// Matches the plain CSS path, and the fingerprinted paths from previous builds
+	extension := path.Ext(manifest.cssPath)
+	cssLinkPattern := regexp.MustCompile(
+		fmt.Sprintf(
+			`"%s(\.[0-9a-f]{%d})?%s"`,
+			regexp.QuoteMeta(strings.TrimSuffix(manifest.cssPath, extension)),
+			fingerprintLength,
+			regexp.QuoteMeta(extension),
+		),
+	)
+	cssPath, err := manifest.Path(manifest.cssPath)
+	if err != nil {
+		return err
+	}
+	cssLink := []byte(`"` + cssPath + `"`)
+
+	for _, page := range cache.OutputsWithSuffix(".html") {
+		content, err := fs.ReadFile(output, page)
+		if err != nil {
+			return wrap.Errorf(err, "failed to read page '%s'", page)
+		}
+
+		linked := cssLinkPattern.ReplaceAll(content, cssLink)
+		if bytes.Equal(linked, content) {
+			continue
+		}
+
+		if err := output.WriteFile(page, linked); err != nil {
+			return err
+		}
+		cache.markRewritten(page)
+	}
+
+	return nil
+}
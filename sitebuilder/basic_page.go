@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"html/template"
+	"time"
 
 	"hermannm.dev/wrap/ctxwrap"
 )
@@ -20,22 +21,54 @@ type BasicPageTemplate struct {
 	Content template.HTML
 }
 
-func (renderer *PageRenderer) RenderBasicPage(ctx context.Context, contentPath string) (err error) {
+func (renderer *PageRenderer) BasicPageStage(
+	contentPath string,
+) (stage BuildStage, page BuildOutput[Page]) {
+	page = newPageOutput(contentPath)
+
+	stage = BuildStage{
+		Name:        fmt.Sprintf("render page '%s'", contentPath),
+		ContentFile: fmt.Sprintf("%s/%s", BaseContentDir, contentPath),
+		// Basic pages use no icons directly, but the footer uses the GitHub icon from commonData
+		Inputs:  []BuildOutputKey{renderedIcons},
+		Outputs: []BuildOutputKey{page},
+		Run: func(ctx context.Context, build BuildState) error {
+			return renderer.RenderBasicPage(ctx, build, contentPath, page)
+		},
+	}
+
+	return stage, page
+}
+
+func (renderer *PageRenderer) RenderBasicPage(
+	ctx context.Context,
+	build BuildState,
+	contentPath string,
+	pageOutput BuildOutput[Page],
+) (err error) {
+	parseStart := time.Now()
 	path := fmt.Sprintf("%s/%s", BaseContentDir, contentPath)
 	body := new(bytes.Buffer)
 	var metadata BasicPageMarkdown
-	if err = readMarkdownWithFrontmatter(ctx, path, body, &metadata); err != nil {
+	frontmatter, err := renderer.readMarkdownWithFrontmatter(ctx, path, body, &metadata, nil)
+	if err != nil {
 		return ctxwrap.Error(ctx, err, "failed to read markdown for page")
 	}
 
 	metadata.Page.TemplateName = BasicPageTemplateName
 	metadata.Page.SetCanonicalURL(renderer.commonData.BaseURL)
 
-	if err = validate.Struct(metadata); err != nil {
+	if err = validateYAML(metadata, frontmatter); err != nil {
 		return ctxwrap.Errorf(ctx, err, "invalid metadata for page '%s'", contentPath)
 	}
 
-	renderer.parsedPages <- metadata.Page
+	pageOutput.Set(build, metadata.Page)
+
+	inputHash, err := renderer.cache.hashPageInputs(metadata.Page.TemplateName, []string{path})
+	if err != nil {
+		return ctxwrap.Errorf(ctx, err, "failed to hash inputs for page '%s'", contentPath)
+	}
+	source := PageSource{ContentFile: path, InputHash: inputHash, ParseTime: time.Since(parseStart)}
 
 	pageTemplate := BasicPageTemplate{
 		Meta: TemplateMetadata{
@@ -48,6 +81,7 @@ func (renderer *PageRenderer) RenderBasicPage(ctx context.Context, contentPath s
 		ctx,
 		pageTemplate.Meta.Page,
 		pageTemplate,
+		source,
 	); err != nil {
 		return ctxwrap.Errorf(ctx, err, "failed to render page '%s'", pageTemplate.Meta.Page.Path)
 	}
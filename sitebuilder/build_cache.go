@@ -0,0 +1,377 @@
+package sitebuilder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"hermannm.dev/errclose"
+	"hermannm.dev/wrap"
+	"hermannm.dev/wrap/ctxwrap"
+)
+
+const (
+	BuildCacheFileName = ".sitebuilder-cache.json"
+	// Build cache for [DevPipeline], see [BuildPipeline.CacheFilePath].
+	DevBuildCacheFileName = ".sitebuilder-dev-cache.json"
+)
+
+// BuildCache keeps track of the inputs used to produce each output file in the previous build, so
+// that we can skip rewriting (and re-formatting) outputs whose inputs have not changed.
+//
+// The input hash for an output combines the hash of inputs shared by all pages (see
+// [BuildCache.SetGlobalInputs]) with the page template and content files specific to that page.
+type BuildCache struct {
+	filePath string
+	// Outputs from the previous build, loaded from the cache file.
+	previousOutputs map[string]string
+	// Outputs produced in this build, whether they were written or unchanged from the previous
+	// build. This is the manifest of generated files, which is saved to the cache file, and used
+	// by [PruneStaleOutputs] to remove files that are no longer generated.
+	outputs map[string]string
+	// Hash of inputs shared by all pages. Blank until SetGlobalInputs is called.
+	globalHash string
+	// Output files that were written in this build.
+	changedFiles []string
+	lock         sync.Mutex
+}
+
+type buildCacheManifest struct {
+	// Maps output file paths to the hash of the inputs used to produce them.
+	Outputs map[string]string `json:"outputs"`
+}
+
+// NewBuildCache creates an empty cache, which will be written to the given file path on
+// [BuildCache.Save].
+func NewBuildCache(filePath string) *BuildCache {
+	return &BuildCache{
+		filePath:        filePath,
+		previousOutputs: make(map[string]string),
+		outputs:         make(map[string]string),
+		globalHash:      "",
+		changedFiles:    nil,
+		lock:            sync.Mutex{},
+	}
+}
+
+// LoadBuildCache reads the cache manifest from the given file path. If the file does not exist, an
+// empty cache is returned.
+func LoadBuildCache(ctx context.Context, filePath string) (*BuildCache, error) {
+	cache := NewBuildCache(filePath)
+
+	manifestBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return cache, nil
+		}
+		return nil, ctxwrap.Errorf(ctx, err, "failed to read build cache file '%s'", filePath)
+	}
+
+	var manifest buildCacheManifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return nil, ctxwrap.Errorf(ctx, err, "failed to parse build cache file '%s'", filePath)
+	}
+	if manifest.Outputs != nil {
+		cache.previousOutputs = manifest.Outputs
+	}
+
+	return cache, nil
+}
+
+// Save writes the manifest of outputs produced in this build to disk. It should only be called once
+// all post-processing of changed files (formatting, CSS generation) has succeeded, so that a failed
+// build is retried in full on the next run.
+func (cache *BuildCache) Save(ctx context.Context) error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	manifest := buildCacheManifest{Outputs: cache.outputs}
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return ctxwrap.Error(ctx, err, "failed to serialize build cache")
+	}
+
+	if err := os.WriteFile(cache.filePath, manifestBytes, 0o644); err != nil {
+		return ctxwrap.Errorf(ctx, err, "failed to write build cache file '%s'", cache.filePath)
+	}
+
+	return nil
+}
+
+// ChangedFiles returns the output files that were written in this build, sorted by path.
+func (cache *BuildCache) ChangedFiles() []string {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	changedFiles := slices.Clone(cache.changedFiles)
+	slices.Sort(changedFiles)
+	return changedFiles
+}
+
+// ChangedFilesWithSuffix returns the output files with the given suffix (e.g. ".html") that were
+// written in this build, sorted by path.
+func (cache *BuildCache) ChangedFilesWithSuffix(suffix string) []string {
+	var files []string
+	for _, file := range cache.ChangedFiles() {
+		if strings.HasSuffix(file, suffix) {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// OutputsWithSuffix returns the output files with the given suffix that were produced in this
+// build (whether written or unchanged from the previous build), sorted by path.
+func (cache *BuildCache) OutputsWithSuffix(suffix string) []string {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	var files []string
+	for file := range cache.outputs {
+		if strings.HasSuffix(file, suffix) {
+			files = append(files, file)
+		}
+	}
+	slices.Sort(files)
+	return files
+}
+
+// IsOutput returns true if the given output file was produced in this build.
+func (cache *BuildCache) IsOutput(outputPath string) bool {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	_, ok := cache.outputs[outputPath]
+	return ok
+}
+
+// SetGlobalInputs hashes the inputs that affect every page: the sitebuilder executable itself,
+// the HTML format, the common page data, the icon map (including the SVG files it points to), the
+// static assets, the image pipeline config and the component templates. Must be called before
+// rendering pages.
+//
+// Pages include output paths of assets (which are fingerprinted with a hash of their content) and
+// image dimensions, so changing any static asset re-renders all pages. This is simpler than
+// tracking which pages use which assets, and assets rarely change.
+func (cache *BuildCache) SetGlobalInputs(
+	commonData CommonPageData,
+	icons IconMap,
+	assets *AssetManifest,
+	imageConfig ImageConfig,
+	htmlFormat HTMLFormat,
+) error {
+	hasher := sha256.New()
+
+	_, _ = fmt.Fprintln(hasher, htmlFormat)
+
+	assetFiles := assets.files()
+	for _, assetPath := range sortedKeys(assetFiles) {
+		asset := assetFiles[assetPath]
+		_, _ = fmt.Fprintln(hasher, assetPath, asset.outputPath, asset.contentHash)
+	}
+	if err := json.NewEncoder(hasher).Encode(imageConfig); err != nil {
+		return wrap.Error(err, "failed to hash image config")
+	}
+
+	// The executable changes whenever the sitebuilder code changes, so we use it as the version
+	executablePath, err := os.Executable()
+	if err != nil {
+		return wrap.Error(err, "failed to get path of sitebuilder executable")
+	}
+	if err := hashFile(hasher, executablePath); err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(hasher).Encode(commonData); err != nil {
+		return wrap.Error(err, "failed to hash common page data")
+	}
+	// Not included in the JSON encoding, since the field is unexported
+	_, _ = fmt.Fprintln(hasher, commonData.HasFeeds())
+	// The icon map is encoded with sorted keys, so the hash is stable between builds
+	if err := json.NewEncoder(hasher).Encode(icons); err != nil {
+		return wrap.Error(err, "failed to hash icon map")
+	}
+	for _, iconName := range sortedKeys(icons) {
+		icon := icons[iconName]
+		for _, path := range [...]string{icon.Path, icon.IndexPageFallbackPath} {
+			if path != "" {
+				if err := hashFile(hasher, path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	componentTemplates, err := filepath.Glob(fmt.Sprintf("%s/*.tmpl", ComponentTemplatesDir))
+	if err != nil {
+		return wrap.Error(err, "failed to list component templates")
+	}
+	for _, path := range componentTemplates {
+		if err := hashFile(hasher, path); err != nil {
+			return err
+		}
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.globalHash = hex.EncodeToString(hasher.Sum(nil))
+	return nil
+}
+
+// Returns a hash of everything that the output of the given page depends on: the global inputs,
+// the page's template, the given content files and any extra inputs (such as hashes of other pages
+// that this page includes data from).
+func (cache *BuildCache) hashPageInputs(
+	templateName string,
+	contentFiles []string,
+	extraInputs ...string,
+) (string, error) {
+	hasher := sha256.New()
+
+	cache.lock.Lock()
+	globalHash := cache.globalHash
+	cache.lock.Unlock()
+	if globalHash == "" {
+		return "", errors.New("BuildCache.SetGlobalInputs must be called before rendering pages")
+	}
+	_, _ = io.WriteString(hasher, globalHash)
+
+	if err := hashFile(hasher, fmt.Sprintf("%s/%s", PageTemplatesDir, templateName)); err != nil {
+		return "", err
+	}
+	for _, path := range contentFiles {
+		if err := hashFile(hasher, path); err != nil {
+			return "", err
+		}
+	}
+	for _, input := range extraInputs {
+		_, _ = fmt.Fprintln(hasher, input)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Returns true if the output file was produced from the same inputs in the previous build, and
+// still exists in the given output.
+func (cache *BuildCache) isFresh(output fs.FS, outputPath string, inputHash string) bool {
+	cache.lock.Lock()
+	previousHash, ok := cache.previousOutputs[outputPath]
+	cache.lock.Unlock()
+	if !ok || previousHash != inputHash {
+		return false
+	}
+
+	_, err := fs.Stat(output, outputPath)
+	return err == nil
+}
+
+// Records that the output file was produced in this build. If written is false, the output was
+// unchanged from the previous build (see [BuildCache.isFresh]), but must still be recorded so it is
+// not pruned.
+func (cache *BuildCache) recordOutput(outputPath string, inputHash string, written bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	cache.outputs[outputPath] = inputHash
+	if written {
+		cache.changedFiles = append(cache.changedFiles, outputPath)
+	}
+}
+
+// Records that an output which was already recorded in this build was written again after it was
+// produced (see [AssetManifest.FingerprintCSS]).
+func (cache *BuildCache) markRewritten(outputPath string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if !slices.Contains(cache.changedFiles, outputPath) {
+		cache.changedFiles = append(cache.changedFiles, outputPath)
+	}
+}
+
+func hashFile(hasher hash.Hash, path string) (returnedErr error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return wrap.Errorf(err, "failed to open '%s' for hashing", path)
+	}
+	defer errclose.Closef(file, &returnedErr, "file '%s'", path)
+
+	// Includes the path, so that moving content between files changes the hash
+	_, _ = fmt.Fprintln(hasher, path)
+	if _, err := io.Copy(hasher, file); err != nil {
+		return wrap.Errorf(err, "failed to read '%s' for hashing", path)
+	}
+
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// PruneStaleOutputs removes files from the output that were not produced in this build, such as
+// pages for content that has since been deleted or renamed. Directories left empty are removed as
+// well. Must be called after all outputs have been produced (including generated CSS).
+func PruneStaleOutputs(ctx context.Context, output OutputFS, cache *BuildCache) ([]string, error) {
+	var staleFiles []string
+	var dirs []string
+
+	err := fs.WalkDir(
+		output,
+		".",
+		func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				if path != "." {
+					dirs = append(dirs, path)
+				}
+			} else if !cache.IsOutput(path) {
+				staleFiles = append(staleFiles, path)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, ctxwrap.Error(ctx, err, "failed to list output files")
+	}
+
+	for _, file := range staleFiles {
+		if err := output.Remove(file); err != nil {
+			return nil, ctxwrap.Error(ctx, err, "failed to prune stale output file")
+		}
+	}
+
+	// Removes nested directories before their parents, since WalkDir lists parents first
+	slices.Reverse(dirs)
+	for _, dir := range dirs {
+		entries, err := fs.ReadDir(output, dir)
+		if err != nil {
+			return nil, ctxwrap.Errorf(ctx, err, "failed to read output directory '%s'", dir)
+		}
+		if len(entries) == 0 {
+			if err := output.Remove(dir); err != nil {
+				return nil, ctxwrap.Error(ctx, err, "failed to prune empty output directory")
+			}
+		}
+	}
+
+	return staleFiles, nil
+}
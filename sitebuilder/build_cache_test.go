@@ -0,0 +1,242 @@
+package sitebuilder
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBuildCacheRerendersPagesWithChangedInputs(t *testing.T) {
+	setUpTestSite(t)
+	output := NewMemoryOutput()
+
+	allPages := rebuildTestSite(t, output)
+	if len(allPages) == 0 {
+		t.Fatal("expected first build to write all pages")
+	}
+
+	// Each test case changes the site from the previous case, and is built with the cache from the
+	// previous build
+	testCases := []struct {
+		name          string
+		change        func(t *testing.T)
+		expectedPages []string
+	}{
+		{
+			name:          "unchanged inputs",
+			change:        func(*testing.T) {},
+			expectedPages: nil,
+		},
+		{
+			name:   "project content",
+			change: appendToFile("content/projects/tool.md", "\nMore about the tool.\n"),
+			// The index and tech pages list the project, so they include its content
+			expectedPages: []string{
+				"index.html",
+				"tech/go.html",
+				"tech/go/index.html",
+				"tool.html",
+				"tool/index.html",
+			},
+		},
+		{
+			name:   "post content",
+			change: appendToFile("content/posts/first-post.md", "\nMore words.\n"),
+			// The post listings include the post
+			expectedPages: []string{
+				"posts.html",
+				"posts/2024.html",
+				"posts/2024/index.html",
+				"posts/first-post.html",
+				"posts/first-post/index.html",
+				"posts/index.html",
+			},
+		},
+		{
+			name:          "component template",
+			change:        appendToFile("templates/components/footer.html.tmpl", "\n"),
+			expectedPages: allPages,
+		},
+		{
+			name:          "icon SVG",
+			change:        appendToFile("content/icons/go.svg", "\n"),
+			expectedPages: allPages,
+		},
+		{
+			name:          "image config",
+			change:        replaceInFile("site.yaml", "placeholderWidth: 4", "placeholderWidth: 2"),
+			expectedPages: allPages,
+		},
+		{
+			name:          "unchanged inputs after changes",
+			change:        func(*testing.T) {},
+			expectedPages: nil,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.change(t)
+
+			pages := rebuildTestSite(t, output)
+			if !slices.Equal(pages, testCase.expectedPages) {
+				t.Errorf(
+					"expected re-rendered pages:\n%v\ngot:\n%v",
+					testCase.expectedPages,
+					pages,
+				)
+			}
+		})
+	}
+}
+
+func TestPruneStaleOutputs(t *testing.T) {
+	ctx := context.Background()
+	cacheFilePath := filepath.Join(t.TempDir(), BuildCacheFileName)
+	output := NewMemoryOutput()
+	writeOutput := func(outputPath string) {
+		t.Helper()
+		if err := output.MkdirAll(path.Dir(outputPath)); err != nil {
+			t.Fatal(err)
+		}
+		if err := output.WriteFile(outputPath, []byte(outputPath)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previousBuild := NewBuildCache(cacheFilePath)
+	for _, outputPath := range []string{
+		"index.html",
+		"about.html",
+		"old-project.html",
+		"old-project/index.html",
+		"old-project/screenshot.png",
+		"img/logo.png",
+		"img/old-logo.png",
+	} {
+		writeOutput(outputPath)
+		previousBuild.recordOutput(outputPath, "hash of "+outputPath, true)
+	}
+	if err := previousBuild.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := LoadBuildCache(ctx, cacheFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rewritten with new inputs
+	writeOutput("index.html")
+	cache.recordOutput("index.html", "new hash of index.html", true)
+	// Unchanged from the previous build, so not written again
+	for _, outputPath := range []string{"about.html", "img/logo.png"} {
+		if !cache.isFresh(output, outputPath, "hash of "+outputPath) {
+			t.Fatalf("expected '%s' to be fresh", outputPath)
+		}
+		cache.recordOutput(outputPath, "hash of "+outputPath, false)
+	}
+	// New in this build
+	writeOutput("new-project.html")
+	cache.recordOutput("new-project.html", "hash of new-project.html", true)
+	// Generated outside of the build, like CSS from Tailwind in watch mode
+	writeOutput("styles.css")
+	cache.recordOutput("styles.css", devCSSOutputHash, false)
+
+	prunedFiles, err := PruneStaleOutputs(ctx, output, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedPruned := []string{
+		"img/old-logo.png",
+		"old-project.html",
+		"old-project/index.html",
+		"old-project/screenshot.png",
+	}
+	slices.Sort(prunedFiles)
+	if !slices.Equal(prunedFiles, expectedPruned) {
+		t.Errorf("expected pruned files:\n%v\ngot:\n%v", expectedPruned, prunedFiles)
+	}
+
+	var remainingFiles []string
+	if err := fs.WalkDir(
+		output,
+		".",
+		func(outputPath string, _ fs.DirEntry, err error) error {
+			if outputPath != "." {
+				remainingFiles = append(remainingFiles, outputPath)
+			}
+			return err
+		},
+	); err != nil {
+		t.Fatal(err)
+	}
+	// The directory left empty is removed, while the one with remaining files is kept
+	expectedRemaining := []string{
+		"about.html",
+		"img",
+		"img/logo.png",
+		"index.html",
+		"new-project.html",
+		"styles.css",
+	}
+	if !slices.Equal(remainingFiles, expectedRemaining) {
+		t.Errorf("expected remaining files:\n%v\ngot:\n%v", expectedRemaining, remainingFiles)
+	}
+}
+
+// Renders the site with the cache saved by the previous call, and returns the pages that were
+// written, sorted by path.
+func rebuildTestSite(t *testing.T, output *MemoryOutput) []string {
+	t.Helper()
+
+	ctx := context.Background()
+	cache, err := LoadBuildCache(ctx, BuildCacheFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renderTestSite(t, output, cache)
+	if err := cache.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	return cache.ChangedFilesWithSuffix(".html")
+}
+
+func appendToFile(path string, content string) func(t *testing.T) {
+	return func(t *testing.T) {
+		t.Helper()
+
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func replaceInFile(path string, old string, new string) func(t *testing.T) {
+	return func(t *testing.T) {
+		t.Helper()
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), old) {
+			t.Fatalf("expected '%s' to contain %q", path, old)
+		}
+		replaced := strings.Replace(string(content), old, new, 1)
+		if err := os.WriteFile(path, []byte(replaced), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
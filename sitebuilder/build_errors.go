@@ -0,0 +1,101 @@
+package sitebuilder
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"hermannm.dev/wrap"
+)
+
+// BuildErrors is returned by [BuildGraph.Run] when collecting errors, so that all content problems
+// can be reported from a single build. Errors are grouped by the content file of the stage that
+// failed (see [BuildStage.ContentFile]).
+//
+// Implements the same interfaces as errors from [wrap.Errors], so that it is logged as a list.
+type BuildErrors struct {
+	Files []ContentFileErrors
+	// Names of stages that were skipped, because a stage they depend on failed.
+	SkippedStages []string
+}
+
+type ContentFileErrors struct {
+	// Blank for stages that are not built from a content file (such as rendering icons).
+	ContentFile string
+	// Names of the stages that failed, used in place of the content file when it is blank.
+	StageNames []string
+	Errors     []error
+}
+
+func (errs BuildErrors) Error() string {
+	return wrap.Errors(errs.Unwrap(), errs.WrappingMessage()).Error()
+}
+
+func (errs BuildErrors) Unwrap() []error {
+	wrapped := make([]error, 0, len(errs.Files))
+	for _, file := range errs.Files {
+		wrapped = append(wrapped, wrap.Errors(file.Errors, file.description()))
+	}
+	return wrapped
+}
+
+func (errs BuildErrors) WrappingMessage() string {
+	message := fmt.Sprintf("build failed with errors in %d", len(errs.Files))
+	if len(errs.Files) == 1 {
+		message += " file"
+	} else {
+		message += " files"
+	}
+
+	if len(errs.SkippedStages) != 0 {
+		message += fmt.Sprintf(" (skipped %d dependent build stages)", len(errs.SkippedStages))
+	}
+
+	return message
+}
+
+func (errs *BuildErrors) add(stage *BuildStage, err error) {
+	if stage.ContentFile != "" {
+		for i, file := range errs.Files {
+			if file.ContentFile == stage.ContentFile {
+				errs.Files[i].StageNames = append(file.StageNames, stage.Name)
+				errs.Files[i].Errors = append(file.Errors, err)
+				return
+			}
+		}
+	}
+
+	errs.Files = append(
+		errs.Files,
+		ContentFileErrors{
+			ContentFile: stage.ContentFile,
+			StageNames:  []string{stage.Name},
+			Errors:      []error{err},
+		},
+	)
+}
+
+// Sorts errors by content file, so that the output is stable between builds. Errors from stages
+// without a content file are placed first. Skipped stages are sorted by name.
+func (errs *BuildErrors) sort() {
+	slices.SortFunc(
+		errs.Files,
+		func(file1, file2 ContentFileErrors) int {
+			if (file1.ContentFile == "") != (file2.ContentFile == "") {
+				if file1.ContentFile == "" {
+					return -1
+				}
+				return 1
+			}
+			return strings.Compare(file1.description(), file2.description())
+		},
+	)
+	slices.Sort(errs.SkippedStages)
+}
+
+func (file ContentFileErrors) description() string {
+	if file.ContentFile != "" {
+		return file.ContentFile
+	}
+	return fmt.Sprintf("build stage '%s'", strings.Join(file.StageNames, "', '"))
+}
@@ -0,0 +1,349 @@
+package sitebuilder
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// BuildGraph schedules the stages of a site build. Each stage declares the outputs it needs from
+// other stages, and the outputs it produces itself. When the graph is run, every stage is started
+// as soon as all of its inputs have been produced, concurrently with other stages.
+//
+// This lets us add new kinds of pages without manually coordinating channels between goroutines:
+// a new page type just adds its stages, and declares what it depends on.
+type BuildGraph struct {
+	stages  []*BuildStage
+	outputs map[string]*buildOutputState
+}
+
+type BuildStage struct {
+	Name string
+	// The content file that the stage is built from, if any. Used to group errors when running
+	// the graph with collectErrors (see [BuildErrors]).
+	ContentFile string
+	// Outputs from other stages that must be produced before this stage runs.
+	Inputs []BuildOutputKey
+	// Outputs that this stage must produce (with [BuildOutput.Set]) before returning successfully.
+	// An output may be produced before the stage returns, to let dependent stages start early.
+	Outputs []BuildOutputKey
+	Run     func(ctx context.Context, build BuildState) error
+}
+
+// BuildOutput is a typed handle to a value produced by a [BuildStage].
+type BuildOutput[T any] struct {
+	name string
+}
+
+// BuildOutputKey is implemented by [BuildOutput], to let stages declare their inputs and outputs
+// regardless of output type.
+type BuildOutputKey interface {
+	outputName() string
+}
+
+// BuildState is passed to [BuildStage.Run], to get inputs and set outputs for the stage.
+type BuildState struct {
+	stage *BuildStage
+	graph *BuildGraph
+}
+
+type buildOutputState struct {
+	producer *BuildStage
+	value    any
+	produced chan struct{}
+	setOnce  sync.Once
+	// Closed if the producing stage finished (or was skipped) without producing the output.
+	failed   chan struct{}
+	failOnce sync.Once
+}
+
+func NewBuildGraph() *BuildGraph {
+	return &BuildGraph{stages: nil, outputs: make(map[string]*buildOutputState)}
+}
+
+// NewBuildOutput creates a handle for an output in a [BuildGraph]. The name must be unique among
+// the outputs in the graph, and is used in error messages.
+func NewBuildOutput[T any](name string) BuildOutput[T] {
+	return BuildOutput[T]{name: name}
+}
+
+func (output BuildOutput[T]) outputName() string {
+	return output.name
+}
+
+// Get returns the value of the output. It must only be called from a stage that declared the
+// output in its [BuildStage.Inputs], which guarantees that the value has been produced.
+func (output BuildOutput[T]) Get(build BuildState) T {
+	if !containsOutput(build.stage.Inputs, output) {
+		panic(fmt.Sprintf(
+			"build stage '%s' tried to get output '%s', which is not one of its inputs",
+			build.stage.Name,
+			output.name,
+		))
+	}
+
+	value, ok := build.graph.outputs[output.name].value.(T)
+	if !ok {
+		panic(fmt.Sprintf("build output '%s' had unexpected type", output.name))
+	}
+	return value
+}
+
+// Set produces the value of the output, letting stages that depend on it start. It must only be
+// called once, from the stage that declared the output in its [BuildStage.Outputs].
+func (output BuildOutput[T]) Set(build BuildState, value T) {
+	if !containsOutput(build.stage.Outputs, output) {
+		panic(fmt.Sprintf(
+			"build stage '%s' tried to set output '%s', which is not one of its outputs",
+			build.stage.Name,
+			output.name,
+		))
+	}
+
+	state := build.graph.outputs[output.name]
+	state.setOnce.Do(
+		func() {
+			state.value = value
+			close(state.produced)
+		},
+	)
+}
+
+// GetAll returns the values of all the given outputs, in the same order. See [BuildOutput.Get].
+func GetAll[T any](build BuildState, outputs []BuildOutput[T]) []T {
+	values := make([]T, len(outputs))
+	for i, output := range outputs {
+		values[i] = output.Get(build)
+	}
+	return values
+}
+
+// OutputKeys converts a list of typed outputs to keys, for use in [BuildStage.Inputs] and
+// [BuildStage.Outputs].
+func OutputKeys[T any](outputs ...BuildOutput[T]) []BuildOutputKey {
+	keys := make([]BuildOutputKey, len(outputs))
+	for i, output := range outputs {
+		keys[i] = output
+	}
+	return keys
+}
+
+// AddStage adds the stage to the graph. Fails if one of the stage's outputs is already produced by
+// another stage.
+func (graph *BuildGraph) AddStage(stage BuildStage) error {
+	for _, output := range stage.Outputs {
+		if existing, ok := graph.outputs[output.outputName()]; ok {
+			return fmt.Errorf(
+				"build stages '%s' and '%s' both declare output '%s'",
+				existing.producer.Name,
+				stage.Name,
+				output.outputName(),
+			)
+		}
+	}
+
+	graph.stages = append(graph.stages, &stage)
+	for _, output := range stage.Outputs {
+		graph.outputs[output.outputName()] = &buildOutputState{
+			producer: &stage,
+			value:    nil,
+			produced: make(chan struct{}),
+			setOnce:  sync.Once{},
+			failed:   make(chan struct{}),
+			failOnce: sync.Once{},
+		}
+	}
+
+	return nil
+}
+
+// Run validates the graph, then runs all its stages concurrently, each one starting once its
+// inputs are ready.
+//
+// If collectErrors is false, the context passed to other stages is canceled when a stage fails,
+// and the first error is returned. If collectErrors is true, independent stages keep running when
+// a stage fails, and stages that depend on an output that was not produced are skipped. Errors
+// from all failed stages are then returned together as [BuildErrors].
+//
+// If ctx is canceled (e.g. when the dev server starts a newer build), stages that have not yet
+// started are skipped, and the context's error is returned.
+func (graph *BuildGraph) Run(ctx context.Context, collectErrors bool) error {
+	if err := graph.validate(); err != nil {
+		return err
+	}
+
+	if !collectErrors {
+		group, groupCtx := errgroup.WithContext(ctx)
+		for _, stage := range graph.stages {
+			group.Go(
+				func() error {
+					_, err := graph.runStage(groupCtx, stage)
+					return err
+				},
+			)
+		}
+		if err := group.Wait(); err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+
+	var errs BuildErrors
+	var errsLock sync.Mutex
+	var waitGroup sync.WaitGroup
+	for _, stage := range graph.stages {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+
+			skipped, err := graph.runStage(ctx, stage)
+
+			errsLock.Lock()
+			defer errsLock.Unlock()
+			if skipped {
+				errs.SkippedStages = append(errs.SkippedStages, stage.Name)
+			}
+			if err != nil {
+				errs.add(stage, err)
+			}
+		}()
+	}
+	waitGroup.Wait()
+
+	if len(errs.Files) == 0 {
+		// Stages skipped due to cancellation have not reported any error
+		return ctx.Err()
+	}
+	errs.sort()
+	return errs
+}
+
+// Returns skipped = true if the stage did not run, because one of its inputs was not produced, or
+// the context was canceled.
+func (graph *BuildGraph) runStage(
+	ctx context.Context,
+	stage *BuildStage,
+) (skipped bool, err error) {
+	// Lets stages that depend on this one be skipped, instead of waiting forever
+	defer graph.failUnproducedOutputs(stage)
+
+	for _, input := range stage.Inputs {
+		select {
+		case <-graph.outputs[input.outputName()].produced:
+		case <-graph.outputs[input.outputName()].failed:
+			// The stage that failed to produce the input reports its own error
+			return true, nil
+		case <-ctx.Done():
+			// Another stage has failed (and its error is the one we want to return), or the build
+			// was canceled
+			return true, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return true, nil
+	}
+
+	if err := stage.Run(ctx, BuildState{stage: stage, graph: graph}); err != nil {
+		return false, err
+	}
+
+	for _, output := range stage.Outputs {
+		select {
+		case <-graph.outputs[output.outputName()].produced:
+		default:
+			return false, fmt.Errorf(
+				"build stage '%s' finished without producing its output '%s'",
+				stage.Name,
+				output.outputName(),
+			)
+		}
+	}
+
+	return false, nil
+}
+
+func (graph *BuildGraph) failUnproducedOutputs(stage *BuildStage) {
+	for _, output := range stage.Outputs {
+		state := graph.outputs[output.outputName()]
+		select {
+		case <-state.produced:
+		default:
+			state.failOnce.Do(func() { close(state.failed) })
+		}
+	}
+}
+
+// Checks that every input in the graph is produced by some stage, and that there are no cycles
+// (which would otherwise deadlock the build).
+func (graph *BuildGraph) validate() error {
+	for _, stage := range graph.stages {
+		for _, input := range stage.Inputs {
+			if _, ok := graph.outputs[input.outputName()]; !ok {
+				return fmt.Errorf(
+					"build stage '%s' depends on output '%s', which no stage produces",
+					stage.Name,
+					input.outputName(),
+				)
+			}
+		}
+	}
+
+	const (
+		visiting = iota + 1
+		visited
+	)
+	visitStates := make(map[*BuildStage]int, len(graph.stages))
+	var path []*BuildStage
+
+	var visit func(stage *BuildStage) error
+	visit = func(stage *BuildStage) error {
+		switch visitStates[stage] {
+		case visited:
+			return nil
+		case visiting:
+			cycleStart := slices.Index(path, stage)
+			stageNames := make([]string, 0, len(path)-cycleStart+1)
+			for _, stageInCycle := range path[cycleStart:] {
+				stageNames = append(stageNames, "'"+stageInCycle.Name+"'")
+			}
+			stageNames = append(stageNames, "'"+stage.Name+"'")
+			return fmt.Errorf(
+				"build graph has a dependency cycle: %s",
+				strings.Join(stageNames, " -> "),
+			)
+		}
+
+		visitStates[stage] = visiting
+		path = append(path, stage)
+		for _, input := range stage.Inputs {
+			if err := visit(graph.outputs[input.outputName()].producer); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		visitStates[stage] = visited
+
+		return nil
+	}
+
+	for _, stage := range graph.stages {
+		if err := visit(stage); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func containsOutput(keys []BuildOutputKey, output BuildOutputKey) bool {
+	for _, key := range keys {
+		if key.outputName() == output.outputName() {
+			return true
+		}
+	}
+	return false
+}
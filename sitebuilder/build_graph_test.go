@@ -0,0 +1,291 @@
+package sitebuilder
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBuildGraphValidate(t *testing.T) {
+	outputA := NewBuildOutput[string]("a")
+	outputB := NewBuildOutput[string]("b")
+	outputC := NewBuildOutput[string]("c")
+	outputD := NewBuildOutput[string]("d")
+
+	testCases := []struct {
+		name          string
+		stages        []BuildStage
+		expectedError string
+	}{
+		{
+			name: "no cycles",
+			stages: []BuildStage{
+				testStage("stage a", nil, []BuildOutput[string]{outputA}, nil),
+				testStage("stage b", OutputKeys(outputA), []BuildOutput[string]{outputB}, nil),
+				testStage("stage c", OutputKeys(outputA), []BuildOutput[string]{outputC}, nil),
+				testStage("stage d", OutputKeys(outputB, outputC), nil, nil),
+			},
+			expectedError: "",
+		},
+		{
+			name: "cycle",
+			stages: []BuildStage{
+				testStage("stage a", OutputKeys(outputC), []BuildOutput[string]{outputA}, nil),
+				testStage("stage b", OutputKeys(outputA), []BuildOutput[string]{outputB}, nil),
+				testStage("stage c", OutputKeys(outputB), []BuildOutput[string]{outputC}, nil),
+			},
+			expectedError: "build graph has a dependency cycle: " +
+				"'stage a' -> 'stage c' -> 'stage b' -> 'stage a'",
+		},
+		{
+			name: "stage depends on itself",
+			stages: []BuildStage{
+				testStage("stage a", OutputKeys(outputA), []BuildOutput[string]{outputA}, nil),
+			},
+			expectedError: "build graph has a dependency cycle: 'stage a' -> 'stage a'",
+		},
+		{
+			name: "cycle reached from a stage outside it",
+			stages: []BuildStage{
+				testStage("stage d", OutputKeys(outputA), []BuildOutput[string]{outputD}, nil),
+				testStage("stage a", OutputKeys(outputB), []BuildOutput[string]{outputA}, nil),
+				testStage("stage b", OutputKeys(outputA), []BuildOutput[string]{outputB}, nil),
+			},
+			expectedError: "build graph has a dependency cycle: " +
+				"'stage a' -> 'stage b' -> 'stage a'",
+		},
+		{
+			name: "input without producer",
+			stages: []BuildStage{
+				testStage("stage a", OutputKeys(outputB), []BuildOutput[string]{outputA}, nil),
+			},
+			expectedError: "build stage 'stage a' depends on output 'b', which no stage produces",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			graph := newTestBuildGraph(t, testCase.stages)
+
+			err := graph.validate()
+			if testCase.expectedError == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			} else if err == nil || err.Error() != testCase.expectedError {
+				t.Errorf("expected error %q, got %v", testCase.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestBuildGraphAddStageWithDuplicateOutput(t *testing.T) {
+	output := NewBuildOutput[string]("output")
+
+	graph := newTestBuildGraph(t, []BuildStage{
+		testStage("first", nil, []BuildOutput[string]{output}, nil),
+	})
+	err := graph.AddStage(testStage("second", nil, []BuildOutput[string]{output}, nil))
+
+	expectedError := "build stages 'first' and 'second' both declare output 'output'"
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("expected error %q, got %v", expectedError, err)
+	}
+}
+
+func TestBuildGraphRunWithUnproducedOutput(t *testing.T) {
+	for _, collectErrors := range []bool{false, true} {
+		name := "stop at first error"
+		if collectErrors {
+			name = "collect errors"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			output := NewBuildOutput[string]("output")
+			var dependentRan bool
+
+			graph := newTestBuildGraph(t, []BuildStage{
+				{
+					Name:        "producer",
+					ContentFile: "",
+					Inputs:      nil,
+					Outputs:     OutputKeys(output),
+					Run:         func(context.Context, BuildState) error { return nil },
+				},
+				{
+					Name:        "dependent",
+					ContentFile: "",
+					Inputs:      OutputKeys(output),
+					Outputs:     nil,
+					Run: func(context.Context, BuildState) error {
+						dependentRan = true
+						return nil
+					},
+				},
+			})
+
+			err := graph.Run(context.Background(), collectErrors)
+			expectedError := "build stage 'producer' finished without producing its output 'output'"
+			if err == nil || !strings.Contains(err.Error(), expectedError) {
+				t.Errorf("expected error containing %q, got %v", expectedError, err)
+			}
+			if dependentRan {
+				t.Error("expected stage depending on unproduced output to be skipped")
+			}
+		})
+	}
+}
+
+func TestBuildGraphRunCollectErrors(t *testing.T) {
+	parsedA := NewBuildOutput[string]("parsed a")
+	parsedB := NewBuildOutput[string]("parsed b")
+	icons := NewBuildOutput[string]("icons")
+
+	var ranStages []string
+	var ranStagesLock sync.Mutex
+	trackRun := func(stage BuildStage) BuildStage {
+		run := stage.Run
+		stage.Run = func(ctx context.Context, build BuildState) error {
+			ranStagesLock.Lock()
+			ranStages = append(ranStages, stage.Name)
+			ranStagesLock.Unlock()
+			return run(ctx, build)
+		}
+		return stage
+	}
+
+	parseError := errors.New("invalid frontmatter")
+	iconError := errors.New("missing icon")
+	stages := []BuildStage{
+		testStage("parse a", nil, []BuildOutput[string]{parsedA}, parseError),
+		testStage("parse b", nil, []BuildOutput[string]{parsedB}, nil),
+		testStage("render icons", nil, []BuildOutput[string]{icons}, iconError),
+		testStage("render a", OutputKeys(parsedA), nil, nil),
+		testStage("render b", OutputKeys(parsedB), nil, nil),
+		testStage("render index", OutputKeys(parsedA, parsedB), nil, nil),
+		testStage("render b with icons", OutputKeys(parsedB, icons), nil, nil),
+	}
+	for i := range stages {
+		if strings.HasSuffix(stages[i].Name, " a") {
+			stages[i].ContentFile = "content/a.md"
+		}
+		stages[i] = trackRun(stages[i])
+	}
+	graph := newTestBuildGraph(t, stages)
+
+	err := graph.Run(context.Background(), true)
+
+	var buildErrs BuildErrors
+	if !errors.As(err, &buildErrs) {
+		t.Fatalf("expected BuildErrors, got %v", err)
+	}
+	if len(buildErrs.Files) != 2 {
+		t.Fatalf("expected errors from 2 stages, got %+v", buildErrs.Files)
+	}
+	// Errors from stages without a content file are sorted first
+	if files := buildErrs.Files; !slices.Equal(files[0].StageNames, []string{"render icons"}) ||
+		!errors.Is(files[0].Errors[0], iconError) {
+		t.Errorf("expected first error from 'render icons', got %+v", files[0])
+	}
+	if file := buildErrs.Files[1]; file.ContentFile != "content/a.md" ||
+		!errors.Is(file.Errors[0], parseError) {
+		t.Errorf("expected second error from 'content/a.md', got %+v", file)
+	}
+
+	expectedSkipped := []string{"render a", "render b with icons", "render index"}
+	if !slices.Equal(buildErrs.SkippedStages, expectedSkipped) {
+		t.Errorf("expected skipped stages %v, got %v", expectedSkipped, buildErrs.SkippedStages)
+	}
+	if message := err.Error(); !strings.Contains(message, "skipped 3 dependent build stages") {
+		t.Errorf("expected error message to include number of skipped stages, got %q", message)
+	}
+
+	slices.Sort(ranStages)
+	expectedRan := []string{"parse a", "parse b", "render b", "render icons"}
+	if !slices.Equal(ranStages, expectedRan) {
+		t.Errorf("expected stages %v to run, got %v", expectedRan, ranStages)
+	}
+}
+
+func TestBuildOutputPanicsWhenNotDeclared(t *testing.T) {
+	declared := NewBuildOutput[string]("declared")
+	undeclared := NewBuildOutput[string]("undeclared")
+
+	graph := newTestBuildGraph(t, []BuildStage{
+		testStage("producer", nil, []BuildOutput[string]{declared, undeclared}, nil),
+		testStage("stage", OutputKeys(declared), nil, nil),
+	})
+	stage := graph.stages[1]
+	build := BuildState{stage: stage, graph: graph}
+
+	testCases := []struct {
+		name          string
+		access        func()
+		expectedPanic string
+	}{
+		{
+			name:   "get undeclared input",
+			access: func() { undeclared.Get(build) },
+			expectedPanic: "build stage 'stage' tried to get output 'undeclared', " +
+				"which is not one of its inputs",
+		},
+		{
+			name:   "set undeclared output",
+			access: func() { undeclared.Set(build, "value") },
+			expectedPanic: "build stage 'stage' tried to set output 'undeclared', " +
+				"which is not one of its outputs",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			defer func() {
+				recovered := recover()
+				if recovered != testCase.expectedPanic {
+					t.Errorf("expected panic %q, got %v", testCase.expectedPanic, recovered)
+				}
+			}()
+			testCase.access()
+		})
+	}
+}
+
+// Returns a stage that fails with the given error if it is not nil, and otherwise sets all its
+// outputs to its name.
+func testStage(
+	name string,
+	inputs []BuildOutputKey,
+	outputs []BuildOutput[string],
+	err error,
+) BuildStage {
+	return BuildStage{
+		Name:        name,
+		ContentFile: "",
+		Inputs:      inputs,
+		Outputs:     OutputKeys(outputs...),
+		Run: func(_ context.Context, build BuildState) error {
+			if err != nil {
+				return err
+			}
+			for _, output := range outputs {
+				output.Set(build, name)
+			}
+			return nil
+		},
+	}
+}
+
+func newTestBuildGraph(t *testing.T, stages []BuildStage) *BuildGraph {
+	t.Helper()
+
+	graph := NewBuildGraph()
+	for _, stage := range stages {
+		if err := graph.AddStage(stage); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return graph
+}
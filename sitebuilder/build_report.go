@@ -0,0 +1,169 @@
+package sitebuilder
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hermannm.dev/wrap/ctxwrap"
+)
+
+const DefaultBuildReportPath = "build-report.json"
+
+// BuildReport describes every page produced by a build, with output sizes and timings. It is saved
+// as JSON (see [BuildReport.Save]), so that page weight and slow build steps can be tracked in CI.
+//
+// Page entries are added while rendering. Timings for post-processing steps (Tailwind) are set by
+// the caller, before calling [BuildReport.Finish].
+type BuildReport struct {
+	StartedAt    time.Time      `json:"startedAt"`
+	TotalTime    ReportDuration `json:"totalTimeMs"`
+	RenderTime   ReportDuration `json:"renderTimeMs"`
+	TailwindTime ReportDuration `json:"tailwindTimeMs"`
+	// Sum of the output sizes of all pages.
+	TotalPageBytes int64        `json:"totalPageBytes"`
+	Pages          []PageReport `json:"pages"`
+	lock           sync.Mutex
+}
+
+// PageReport describes a single rendered [Page]. Pages that are served both with and without a
+// trailing slash (see [PageRenderer.renderPageWithAndWithoutTrailingSlash]) get one entry for each.
+type PageReport struct {
+	Path         string `json:"path"`
+	ContentFile  string `json:"contentFile"`
+	Template     string `json:"template"`
+	CanonicalURL string `json:"canonicalURL"`
+	RedirectPath string `json:"redirectPath,omitempty"`
+	// Relative to the output directory.
+	OutputPath string `json:"outputPath"`
+	// Size of the output file after post-processing.
+	Bytes int64 `json:"bytes"`
+	// True if the page was unchanged since the previous build, so it was not rendered again.
+	Cached bool `json:"cached"`
+	// Time spent reading and parsing the page's content. Shared by both variants of pages rendered
+	// with and without a trailing slash.
+	ParseTime ReportDuration `json:"parseTimeMs"`
+	// Zero if the page was cached.
+	TemplateTime ReportDuration `json:"templateTimeMs"`
+	// Time spent formatting the rendered page (see [FormatHTML]). Zero if the page was cached.
+	FormatTime ReportDuration `json:"formatTimeMs"`
+}
+
+// PageSource describes the content that a page was produced from, for the build cache and report.
+type PageSource struct {
+	ContentFile string
+	// Hash of all the inputs to the page, see [BuildCache.hashPageInputs].
+	InputHash string
+	ParseTime time.Duration
+}
+
+// ReportDuration is encoded in JSON as milliseconds, which are easier to read than Go's default
+// encoding of durations as nanoseconds.
+type ReportDuration time.Duration
+
+func (duration ReportDuration) MarshalJSON() ([]byte, error) {
+	millis := float64(duration) / float64(time.Millisecond)
+	return strconv.AppendFloat(nil, millis, 'f', 2, 64), nil
+}
+
+func NewBuildReport() *BuildReport {
+	return &BuildReport{
+		StartedAt:      time.Now(),
+		TotalTime:      0,
+		RenderTime:     0,
+		TailwindTime:   0,
+		TotalPageBytes: 0,
+		Pages:          nil,
+		lock:           sync.Mutex{},
+	}
+}
+
+func (report *BuildReport) addPage(page PageReport) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+
+	report.Pages = append(report.Pages, page)
+}
+
+// Finish sorts the page entries, and reads the sizes of their output files.
+func (report *BuildReport) Finish(ctx context.Context, output fs.FS) error {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+
+	slices.SortFunc(
+		report.Pages,
+		func(page1, page2 PageReport) int {
+			return strings.Compare(page1.OutputPath, page2.OutputPath)
+		},
+	)
+
+	report.TotalPageBytes = 0
+	for i, page := range report.Pages {
+		fileInfo, err := fs.Stat(output, page.OutputPath)
+		if err != nil {
+			return ctxwrap.Errorf(ctx, err, "failed to get size of page '%s'", page.OutputPath)
+		}
+		report.Pages[i].Bytes = fileInfo.Size()
+		report.TotalPageBytes += fileInfo.Size()
+	}
+
+	report.TotalTime = ReportDuration(time.Since(report.StartedAt))
+	return nil
+}
+
+func (report *BuildReport) Save(ctx context.Context, path string) error {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+
+	reportBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return ctxwrap.Error(ctx, err, "failed to serialize build report")
+	}
+
+	if err := os.WriteFile(path, reportBytes, 0o644); err != nil {
+		return ctxwrap.Errorf(ctx, err, "failed to write build report to '%s'", path)
+	}
+
+	return nil
+}
+
+// SummaryLogAttributes returns key-value pairs summarizing the report, for passing to a logger.
+func (report *BuildReport) SummaryLogAttributes() []any {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+
+	renderedPages := 0
+	var largestPage, slowestPage PageReport
+	for _, page := range report.Pages {
+		if !page.Cached {
+			renderedPages++
+		}
+		if page.Bytes > largestPage.Bytes {
+			largestPage = page
+		}
+		if page.totalTime() > slowestPage.totalTime() {
+			slowestPage = page
+		}
+	}
+
+	return []any{
+		"pages", len(report.Pages),
+		"renderedPages", renderedPages,
+		"totalPageBytes", report.TotalPageBytes,
+		"largestPage", largestPage.OutputPath,
+		"slowestPage", slowestPage.OutputPath,
+		"renderTime", time.Duration(report.RenderTime),
+		"tailwindTime", time.Duration(report.TailwindTime),
+		"totalTime", time.Duration(report.TotalTime),
+	}
+}
+
+func (page PageReport) totalTime() ReportDuration {
+	return page.ParseTime + page.TemplateTime + page.FormatTime
+}
@@ -0,0 +1,154 @@
+package sitebuilder
+
+import (
+	"context"
+	"time"
+
+	"hermannm.dev/devlog/log"
+	"hermannm.dev/wrap/ctxwrap"
+)
+
+// BuildPipeline selects how CSS is generated in [BuildSite].
+type BuildPipeline string
+
+const (
+	// Generates minified CSS with Tailwind after rendering pages, and fingerprints assets for
+	// long-term caching (see [AssetManifest]).
+	ProductionPipeline BuildPipeline = "production"
+	// Leaves CSS generation to a Tailwind process in watch mode (managed by the dev server), which
+	// keeps rebuilds fast. Assets keep their plain names, so the CSS can be regenerated in place.
+	DevPipeline BuildPipeline = "dev"
+)
+
+// Recorded in the build cache for the CSS output in [DevPipeline], so that the CSS is not pruned.
+const devCSSOutputHash = "generated by Tailwind in watch mode"
+
+// OutputDir returns the directory that builds with the pipeline are written to. Dev builds have
+// their own output directory and build cache, so that unminified CSS and unformatted pages from the
+// dev server never end up in the deployed site, and so that switching between the pipelines does
+// not make the next build re-render every page.
+func (pipeline BuildPipeline) OutputDir() string {
+	if pipeline == DevPipeline {
+		return DevOutputDir
+	}
+	return BaseOutputDir
+}
+
+// CacheFilePath returns the path of the build cache for the pipeline, see
+// [BuildPipeline.OutputDir].
+func (pipeline BuildPipeline) CacheFilePath() string {
+	if pipeline == DevPipeline {
+		return DevBuildCacheFileName
+	}
+	return BuildCacheFileName
+}
+
+// BuildResult is returned by [BuildSite].
+type BuildResult struct {
+	Report *BuildReport
+	// Output files that were written in this build, see [BuildCache.ChangedFiles].
+	ChangedFiles []string
+	// Output files from previous builds that were removed, see [PruneStaleOutputs].
+	PrunedFiles []string
+}
+
+// BuildSite renders all pages to the output directory, then generates CSS with the given pipeline,
+// prunes stale outputs and saves the build cache. This is the full build used both by
+// the CLI and the dev server.
+func BuildSite(
+	ctx context.Context,
+	config SiteConfig,
+	output DiskOutput,
+	cache *BuildCache,
+	pipeline BuildPipeline,
+	options RenderOptions,
+) (BuildResult, error) {
+	report := NewBuildReport()
+
+	assets, err := LoadAssetManifest(ctx, config.InputCSSFile, pipeline == ProductionPipeline)
+	if err != nil {
+		return BuildResult{}, err
+	}
+
+	renderStart := time.Now()
+	if err := RenderPages(ctx, RenderConfig{
+		ContentPaths: config.ContentPaths,
+		CommonData:   config.CommonPageData,
+		Icons:        config.Icons,
+		Images:       config.Images,
+		Assets:       assets,
+		Output:       output,
+		Cache:        cache,
+		Report:       report,
+		Options:      options,
+	}); err != nil {
+		return BuildResult{}, err
+	}
+	report.RenderTime = ReportDuration(time.Since(renderStart))
+
+	switch pipeline {
+	case ProductionPipeline:
+		tailwindStart := time.Now()
+		if err := GenerateTailwindCSS(ctx, config.InputCSSFile, output, cache); err != nil {
+			return BuildResult{}, ctxwrap.Error(
+				ctx,
+				err,
+				"failed to generate CSS for rendered pages",
+			)
+		}
+		report.TailwindTime = ReportDuration(time.Since(tailwindStart))
+
+		if err := assets.FingerprintCSS(ctx, output, cache); err != nil {
+			return BuildResult{}, err
+		}
+	case DevPipeline:
+		cache.recordOutput(CSSOutputPath(config.InputCSSFile), devCSSOutputHash, false)
+	default:
+		return BuildResult{}, ctxwrap.NewErrorf(ctx, "unknown build pipeline '%s'", pipeline)
+	}
+
+	// A canceled build may not have written all its outputs, so it must not prune or save the cache
+	if err := ctx.Err(); err != nil {
+		return BuildResult{}, err
+	}
+
+	prunedFiles, err := PruneStaleOutputs(ctx, output, cache)
+	if err != nil {
+		return BuildResult{}, ctxwrap.Error(ctx, err, "failed to prune stale output files")
+	}
+
+	if err := cache.Save(ctx); err != nil {
+		return BuildResult{}, err
+	}
+
+	if err := report.Finish(ctx, output); err != nil {
+		return BuildResult{}, ctxwrap.Error(ctx, err, "failed to finish build report")
+	}
+
+	return BuildResult{
+		Report:       report,
+		ChangedFiles: cache.ChangedFiles(),
+		PrunedFiles:  prunedFiles,
+	}, nil
+}
+
+// LoadBuildCacheOrEmpty loads the build cache for the given pipeline (see
+// [BuildPipeline.CacheFilePath]), or returns an empty cache (rebuilding all pages) if fullRebuild
+// is true or the cache fails to load.
+func LoadBuildCacheOrEmpty(
+	ctx context.Context,
+	pipeline BuildPipeline,
+	fullRebuild bool,
+) *BuildCache {
+	cacheFilePath := pipeline.CacheFilePath()
+	if fullRebuild {
+		return NewBuildCache(cacheFilePath)
+	}
+
+	cache, err := LoadBuildCache(ctx, cacheFilePath)
+	if err != nil {
+		log.WarnError(ctx, err, "Failed to load build cache, rebuilding all pages")
+		return NewBuildCache(cacheFilePath)
+	}
+	return cache
+}
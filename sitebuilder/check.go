@@ -0,0 +1,73 @@
+package sitebuilder
+
+import (
+	"context"
+	"os"
+
+	"hermannm.dev/wrap/ctxwrap"
+)
+
+// CheckContent parses, validates and renders all content for the given site config, without writing
+// anything to disk or generating CSS. Pages are rendered to memory,
+// so that errors in templates and image lookups are caught as well. Every page URL is then checked
+// with [VisitGitHubPagesPath], to verify trailing slash and redirect behavior.
+//
+// Errors from all pages are collected and returned together, see [BuildErrors]. On success, returns
+// a report of the pages that would have been produced.
+func CheckContent(ctx context.Context, config SiteConfig) (*BuildReport, error) {
+	if _, err := os.Stat(config.InputCSSFile); err != nil {
+		return nil, ctxwrap.Errorf(
+			ctx,
+			err,
+			"failed to find input CSS file '%s'",
+			config.InputCSSFile,
+		)
+	}
+
+	// Assets are not fingerprinted, since CSS is not generated
+	assets, err := LoadAssetManifest(ctx, config.InputCSSFile, false)
+	if err != nil {
+		return nil, err
+	}
+
+	output := NewMemoryOutput()
+	// The cache is never saved, it is only required by the renderer
+	cache := NewBuildCache("")
+	report := NewBuildReport()
+
+	if err := RenderPages(ctx, RenderConfig{
+		ContentPaths: config.ContentPaths,
+		CommonData:   config.CommonPageData,
+		Icons:        config.Icons,
+		Images:       config.Images,
+		Assets:       assets,
+		Output:       output,
+		Cache:        cache,
+		Report:       report,
+		Options: RenderOptions{
+			CollectErrors:          true,
+			UnknownFrontmatterKeys: config.UnknownFrontmatterKeys,
+			HTMLFormat:             config.HTMLFormat,
+			// Encoding images is too slow for checking content, and image errors are caught
+			// when planning variants
+			SkipImageEncoding: true,
+		},
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := report.Finish(ctx, output); err != nil {
+		return nil, err
+	}
+
+	if errs := VerifyPageURLs(output, report.Pages); len(errs) != 0 {
+		return nil, ctxwrap.Errorsf(
+			ctx,
+			errs,
+			"%d page URLs do not resolve as expected on GitHub Pages",
+			len(errs),
+		)
+	}
+
+	return report, nil
+}
@@ -15,6 +15,8 @@ type LinkItem struct {
 	Icon     template.HTML `yaml:"-"`        // Populated if IconName is set.
 	// We use this in our HTML templates to not use bold text for sublink titles.
 	IsSublink bool `yaml:"-"`
+	// Links to other pages on the site (such as tech pages) open in the same tab.
+	IsInternal bool `yaml:"-"`
 }
 
 func (linkItem *LinkItem) populateLinkText() {
@@ -32,11 +34,13 @@ type TemplateMetadata struct {
 }
 
 type CommonPageData struct {
-	SiteName         string `validate:"required"`
-	SiteDescription  string `validate:"required"`
-	BaseURL          string `validate:"required,url"`
-	GitHubIssuesLink string `validate:"required,url"`
+	SiteName         string `yaml:"siteName"         validate:"required"`
+	SiteDescription  string `yaml:"siteDescription"  validate:"required"`
+	BaseURL          string `yaml:"baseURL"          validate:"required,url"`
+	GitHubIssuesLink string `yaml:"githubIssuesLink" validate:"required,url"`
 	githubIcon       template.HTML
+	// Set by [RenderPages] when the site has posts, since feeds are only generated then.
+	hasFeeds bool
 }
 
 func (commonData CommonPageData) GitHubIcon() template.HTML {
@@ -99,4 +103,8 @@ var TemplateFunctions = template.FuncMap{
 
 		return template.HTML(builder.String())
 	},
+	// For the onload attribute of images with a placeholder style, see [removePlaceholderScript].
+	"removePlaceholderScript": func() template.JS {
+		return removePlaceholderScript
+	},
 }
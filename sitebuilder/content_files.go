@@ -0,0 +1,90 @@
+package sitebuilder
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"hermannm.dev/wrap/ctxwrap"
+)
+
+// Date format for dates in frontmatter (such as post dates), also used in the datetime attribute of
+// <time> elements.
+const frontmatterDateFormat = time.DateOnly
+
+// ContentFile is a markdown file in one of the content directories for projects or posts, or a page
+// bundle directory (see [PageBundle]).
+type ContentFile struct {
+	// File name, or directory name for page bundles.
+	name      string
+	directory string
+	// See [PageBundle].
+	isBundle bool
+}
+
+func (file ContentFile) path() string {
+	if file.isBundle {
+		return fmt.Sprintf("%s/%s/%s", file.directory, file.name, PageBundleIndexFile)
+	}
+	return fmt.Sprintf("%s/%s", file.directory, file.name)
+}
+
+// Returns the path of the file including [BaseContentDir].
+func (file ContentFile) contentFilePath() string {
+	return fmt.Sprintf("%s/%s", BaseContentDir, file.path())
+}
+
+// Returns the file name without the .md extension, or the directory name for page bundles.
+func (file ContentFile) slug() string {
+	return strings.TrimSuffix(file.name, ".md")
+}
+
+// Lists the markdown files and page bundles in the given directories under [BaseContentDir].
+func readContentDirs(ctx context.Context, contentDirNames []string) (
+	[]ContentFile,
+	error,
+) {
+	var files []ContentFile
+	baseContentDir := os.DirFS(BaseContentDir)
+
+	for _, dirName := range contentDirNames {
+		entries, err := fs.ReadDir(baseContentDir, dirName)
+		if err != nil {
+			return nil, ctxwrap.Errorf(
+				ctx,
+				err,
+				"failed to read content directory '%s'",
+				dirName,
+			)
+		}
+
+		for _, dirEntry := range entries {
+			file := ContentFile{
+				name:      dirEntry.Name(),
+				directory: dirName,
+				isBundle:  dirEntry.IsDir(),
+			}
+
+			if file.isBundle {
+				_, err := fs.Stat(baseContentDir, file.path())
+				if err != nil {
+					return nil, ctxwrap.Errorf(
+						ctx,
+						err,
+						"directory '%s' in '%s' is not a page bundle (missing '%s' file)",
+						file.name,
+						dirName,
+						PageBundleIndexFile,
+					)
+				}
+			}
+
+			files = append(files, file)
+		}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,430 @@
+package sitebuilder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"html/template"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"hermannm.dev/wrap"
+	"hermannm.dev/wrap/ctxwrap"
+)
+
+const (
+	AtomFeedPath = "/feed.xml"
+	RSSFeedPath  = "/rss.xml"
+	JSONFeedPath = "/feed.json"
+)
+
+// HasFeeds returns true if the site's feeds are generated, so that page templates only link to
+// them then. Feeds are only generated for sites with posts, so they always have at least one entry.
+func (commonData CommonPageData) HasFeeds() bool {
+	return commonData.hasFeeds
+}
+
+// AtomFeedURL returns the absolute URL of the Atom feed, for links to it in page templates.
+func (commonData CommonPageData) AtomFeedURL() string {
+	return commonData.BaseURL + AtomFeedPath
+}
+
+// RSSFeedURL returns the absolute URL of the RSS feed, for links to it in page templates.
+func (commonData CommonPageData) RSSFeedURL() string {
+	return commonData.BaseURL + RSSFeedPath
+}
+
+// JSONFeedURL returns the absolute URL of the JSON feed, for links to it in page templates.
+func (commonData CommonPageData) JSONFeedURL() string {
+	return commonData.BaseURL + JSONFeedPath
+}
+
+// FeedEntry is an item in the site's feeds: a post, or a project with a date.
+type FeedEntry struct {
+	Title string
+	// Absolute URL of the entry's page.
+	URL     string
+	Date    time.Time
+	Summary string
+	// Rendered from Markdown, with relative URLs made absolute (see [absoluteURLsInHTML]), since
+	// feed readers show the content outside of the site.
+	Content template.HTML
+	Tags    []string
+}
+
+// FeedsStage returns a build stage that writes Atom, RSS and JSON feeds with the given posts, and
+// the projects that have a date.
+func (renderer *PageRenderer) FeedsStage(
+	projects []BuildOutput[ParsedProject],
+	posts []BuildOutput[ParsedPost],
+) BuildStage {
+	return BuildStage{
+		Name:        "build feeds",
+		ContentFile: "",
+		Inputs:      append(OutputKeys(projects...), OutputKeys(posts...)...),
+		Outputs:     nil,
+		Run: func(ctx context.Context, build BuildState) error {
+			var entries []FeedEntry
+			for _, project := range GetAll(build, projects) {
+				if project.Date.IsZero() {
+					continue
+				}
+
+				tags := make([]string, 0, len(project.TechStack))
+				for _, tech := range project.TechStack {
+					tags = append(tags, tech.LinkText)
+				}
+				entries = append(entries, renderer.newFeedEntry(
+					project.Name,
+					project.Page,
+					project.Date,
+					project.TagLine,
+					project.Description,
+					tags,
+				))
+			}
+			for _, post := range GetAll(build, posts) {
+				entries = append(entries, renderer.newFeedEntry(
+					post.Title,
+					post.Page,
+					post.Date,
+					post.Summary,
+					post.Content,
+					post.Tags,
+				))
+			}
+
+			return renderer.BuildFeeds(ctx, entries)
+		},
+	}
+}
+
+func (renderer *PageRenderer) newFeedEntry(
+	title string,
+	page Page,
+	date time.Time,
+	summary string,
+	content template.HTML,
+	tags []string,
+) FeedEntry {
+	return FeedEntry{
+		Title:   title,
+		URL:     page.CanonicalURL,
+		Date:    date,
+		Summary: summary,
+		Content: absoluteURLsInHTML(content, renderer.commonData.BaseURL, page.CanonicalURL),
+		Tags:    tags,
+	}
+}
+
+// BuildFeeds writes the given entries to the Atom, RSS and JSON feeds, newest first. Fails if there
+// are no entries, since the feeds then have no date to give as their last update.
+func (renderer *PageRenderer) BuildFeeds(ctx context.Context, entries []FeedEntry) error {
+	if len(entries) == 0 {
+		return ctxwrap.NewError(ctx, "feeds must have at least one entry")
+	}
+
+	entries = slices.Clone(entries)
+	slices.SortFunc(
+		entries,
+		func(entry1 FeedEntry, entry2 FeedEntry) int {
+			if order := entry2.Date.Compare(entry1.Date); order != 0 {
+				return order
+			}
+			return strings.Compare(entry1.Title, entry2.Title)
+		},
+	)
+
+	// Feeds must say when they were last updated. We use the date of the newest entry instead of
+	// the build time, so that feeds are only rewritten when their content changes.
+	updated := entries[0].Date
+
+	feeds := []struct {
+		path   string
+		encode func(entries []FeedEntry, updated time.Time) ([]byte, error)
+	}{
+		{AtomFeedPath, renderer.encodeAtomFeed},
+		{RSSFeedPath, renderer.encodeRSSFeed},
+		{JSONFeedPath, renderer.encodeJSONFeed},
+	}
+	for _, feed := range feeds {
+		content, err := feed.encode(entries, updated)
+		if err != nil {
+			return ctxwrap.Errorf(ctx, err, "failed to encode feed '%s'", feed.path)
+		}
+
+		if err := renderer.writeGeneratedFile(
+			ctx,
+			strings.TrimPrefix(feed.path, "/"),
+			content,
+		); err != nil {
+			return ctxwrap.Errorf(ctx, err, "failed to write feed '%s'", feed.path)
+		}
+	}
+
+	return nil
+}
+
+// See https://www.rfc-editor.org/rfc/rfc4287.
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle"`
+	Updated  string      `xml:"updated"`
+	Author   atomAuthor  `xml:"author"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Summary    string         `xml:"summary"`
+	Content    atomContent    `xml:"content"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",cdata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+func (renderer *PageRenderer) encodeAtomFeed(
+	entries []FeedEntry,
+	updated time.Time,
+) ([]byte, error) {
+	feed := atomFeed{
+		XMLName:  xml.Name{},
+		ID:       renderer.commonData.BaseURL + "/",
+		Title:    renderer.commonData.SiteName,
+		Subtitle: renderer.commonData.SiteDescription,
+		Updated:  updated.Format(time.RFC3339),
+		Author: atomAuthor{
+			Name: renderer.commonData.SiteName,
+			URI:  renderer.commonData.BaseURL,
+		},
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: renderer.commonData.AtomFeedURL()},
+			{Rel: "alternate", Type: "text/html", Href: renderer.commonData.BaseURL},
+		},
+		Entries: make([]atomEntry, 0, len(entries)),
+	}
+
+	for _, entry := range entries {
+		categories := make([]atomCategory, 0, len(entry.Tags))
+		for _, tag := range entry.Tags {
+			categories = append(categories, atomCategory{Term: tag})
+		}
+
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:         entry.URL,
+			Title:      entry.Title,
+			Link:       atomLink{Rel: "alternate", Type: "text/html", Href: entry.URL},
+			Published:  entry.Date.Format(time.RFC3339),
+			Updated:    entry.Date.Format(time.RFC3339),
+			Summary:    entry.Summary,
+			Content:    atomContent{Type: "html", Body: string(entry.Content)},
+			Categories: categories,
+		})
+	}
+
+	return encodeXMLFeed(feed)
+}
+
+// See https://www.rssboard.org/rss-specification.
+type rssFeed struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	AtomXMLNS string     `xml:"xmlns:atom,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string `xml:"title"`
+	Link          string `xml:"link"`
+	Description   string `xml:"description"`
+	LastBuildDate string `xml:"lastBuildDate"`
+	// RSS has no link to the feed itself, so we use Atom's, as recommended by the RSS Advisory
+	// Board.
+	SelfLink atomLink  `xml:"atom:link"`
+	Items    []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Description xmlCDATA `xml:"description"`
+	Categories  []string `xml:"category"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func (renderer *PageRenderer) encodeRSSFeed(
+	entries []FeedEntry,
+	updated time.Time,
+) ([]byte, error) {
+	feed := rssFeed{
+		XMLName:   xml.Name{},
+		Version:   "2.0",
+		AtomXMLNS: "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         renderer.commonData.SiteName,
+			Link:          renderer.commonData.BaseURL,
+			Description:   renderer.commonData.SiteDescription,
+			LastBuildDate: updated.Format(time.RFC1123Z),
+			SelfLink: atomLink{
+				Rel:  "self",
+				Type: "application/rss+xml",
+				Href: renderer.commonData.RSSFeedURL(),
+			},
+			Items: make([]rssItem, 0, len(entries)),
+		},
+	}
+
+	for _, entry := range entries {
+		// RSS items have no separate summary, so the description holds the full content
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       entry.Title,
+			Link:        entry.URL,
+			GUID:        rssGUID{IsPermaLink: true, Value: entry.URL},
+			PubDate:     entry.Date.Format(time.RFC1123Z),
+			Description: xmlCDATA{Value: string(entry.Content)},
+			Categories:  entry.Tags,
+		})
+	}
+
+	return encodeXMLFeed(feed)
+}
+
+// Writes HTML content in feeds as CDATA, so it is readable without unescaping.
+type xmlCDATA struct {
+	Value string `xml:",cdata"`
+}
+
+func encodeXMLFeed(feed any) ([]byte, error) {
+	content, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, wrap.Error(err, "failed to marshal XML")
+	}
+	return []byte(xml.Header + string(content) + "\n"), nil
+}
+
+// See https://www.jsonfeed.org/version/1.1/.
+type jsonFeed struct {
+	Version     string          `json:"version"`
+	Title       string          `json:"title"`
+	HomePageURL string          `json:"home_page_url"`
+	FeedURL     string          `json:"feed_url"`
+	Description string          `json:"description"`
+	Items       []jsonFeedEntry `json:"items"`
+}
+
+type jsonFeedEntry struct {
+	ID            string   `json:"id"`
+	URL           string   `json:"url"`
+	Title         string   `json:"title"`
+	ContentHTML   string   `json:"content_html"`
+	Summary       string   `json:"summary"`
+	DatePublished string   `json:"date_published"`
+	Tags          []string `json:"tags,omitempty"`
+}
+
+func (renderer *PageRenderer) encodeJSONFeed(entries []FeedEntry, _ time.Time) ([]byte, error) {
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       renderer.commonData.SiteName,
+		HomePageURL: renderer.commonData.BaseURL,
+		FeedURL:     renderer.commonData.JSONFeedURL(),
+		Description: renderer.commonData.SiteDescription,
+		Items:       make([]jsonFeedEntry, 0, len(entries)),
+	}
+
+	for _, entry := range entries {
+		feed.Items = append(feed.Items, jsonFeedEntry{
+			ID:            entry.URL,
+			URL:           entry.URL,
+			Title:         entry.Title,
+			ContentHTML:   string(entry.Content),
+			Summary:       entry.Summary,
+			DatePublished: entry.Date.Format(time.RFC3339),
+			Tags:          entry.Tags,
+		})
+	}
+
+	var content bytes.Buffer
+	encoder := json.NewEncoder(&content)
+	encoder.SetIndent("", "  ")
+	// Keeps HTML content readable, since the feed is not embedded in an HTML page
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(feed); err != nil {
+		return nil, wrap.Error(err, "failed to marshal JSON")
+	}
+	return content.Bytes(), nil
+}
+
+var (
+	// Matches href and src attributes with root-relative URLs (but not protocol-relative URLs like
+	// "//example.com") or fragments.
+	relativeURLAttributePattern = regexp.MustCompile(`\s(?:href|src)="(/(?:[^/"][^"]*)?|#[^"]*)"`)
+	srcsetAttributePattern      = regexp.MustCompile(`\ssrcset="([^"]*)"`)
+)
+
+// Makes root-relative URLs in the given HTML absolute by prefixing them with the base URL, and
+// fragment links (such as footnote references) absolute by prefixing them with the page URL.
+// Assumes that attributes are double-quoted, which goldmark always does.
+func absoluteURLsInHTML(html template.HTML, baseURL string, pageURL string) template.HTML {
+	replaced := relativeURLAttributePattern.ReplaceAllStringFunc(
+		string(html),
+		func(attribute string) string {
+			urlStart := strings.IndexByte(attribute, '"') + 1
+			prefix := baseURL
+			if attribute[urlStart] == '#' {
+				prefix = pageURL
+			}
+			return attribute[:urlStart] + prefix + attribute[urlStart:]
+		},
+	)
+
+	replaced = srcsetAttributePattern.ReplaceAllStringFunc(
+		replaced,
+		func(attribute string) string {
+			// Each candidate in a srcset is a URL followed by a width or pixel density descriptor
+			candidates := strings.Split(attribute[len(` srcset="`):len(attribute)-1], ", ")
+			for i, candidate := range candidates {
+				if strings.HasPrefix(candidate, "/") && !strings.HasPrefix(candidate, "//") {
+					candidates[i] = baseURL + candidate
+				}
+			}
+			return attribute[:len(` srcset="`)] + strings.Join(candidates, ", ") + `"`
+		},
+	)
+
+	return template.HTML(replaced)
+}
@@ -0,0 +1,230 @@
+package sitebuilder
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"html/template"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildFeeds(t *testing.T) {
+	output := NewMemoryOutput()
+	//nolint:exhaustruct
+	renderer := PageRenderer{
+		commonData: CommonPageData{
+			SiteName:        "example.dev",
+			SiteDescription: "An example site.",
+			BaseURL:         "https://example.dev",
+		},
+		output: output,
+		cache:  NewBuildCache(""),
+	}
+
+	newest := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	middle := time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC)
+	oldest := time.Date(2023, time.December, 1, 0, 0, 0, 0, time.UTC)
+	entries := []FeedEntry{
+		testFeedEntry("Oldest post", oldest),
+		testFeedEntry("B post", middle),
+		testFeedEntry("Newest project", newest),
+		testFeedEntry("A post", middle),
+	}
+	if err := renderer.BuildFeeds(context.Background(), entries); err != nil {
+		t.Fatal(err)
+	}
+	// Entries with the same date are sorted by title, so the order is stable between builds
+	expectedTitles := []string{"Newest project", "A post", "B post", "Oldest post"}
+
+	var atom atomFeed
+	if err := xml.Unmarshal([]byte(readOutputFile(t, output, "feed.xml")), &atom); err != nil {
+		t.Fatalf("expected Atom feed to be valid XML: %v", err)
+	}
+	var atomTitles []string
+	for _, entry := range atom.Entries {
+		atomTitles = append(atomTitles, entry.Title)
+	}
+	expectFeedTitles(t, "Atom", atomTitles, expectedTitles)
+	if atom.Updated != newest.Format(time.RFC3339) {
+		t.Errorf("expected Atom feed to be updated at newest entry, got '%s'", atom.Updated)
+	}
+
+	var rss rssFeed
+	if err := xml.Unmarshal([]byte(readOutputFile(t, output, "rss.xml")), &rss); err != nil {
+		t.Fatalf("expected RSS feed to be valid XML: %v", err)
+	}
+	var rssTitles []string
+	for _, item := range rss.Channel.Items {
+		rssTitles = append(rssTitles, item.Title)
+	}
+	expectFeedTitles(t, "RSS", rssTitles, expectedTitles)
+	if rss.Channel.LastBuildDate != newest.Format(time.RFC1123Z) {
+		t.Errorf(
+			"expected RSS feed to be updated at newest entry, got '%s'",
+			rss.Channel.LastBuildDate,
+		)
+	}
+
+	var jsonFeedContent jsonFeed
+	if err := json.Unmarshal(
+		[]byte(readOutputFile(t, output, "feed.json")),
+		&jsonFeedContent,
+	); err != nil {
+		t.Fatalf("expected JSON feed to be valid JSON: %v", err)
+	}
+	var jsonTitles []string
+	for _, item := range jsonFeedContent.Items {
+		jsonTitles = append(jsonTitles, item.Title)
+	}
+	expectFeedTitles(t, "JSON", jsonTitles, expectedTitles)
+}
+
+func TestBuildFeedsWithoutEntries(t *testing.T) {
+	//nolint:exhaustruct
+	renderer := PageRenderer{output: NewMemoryOutput(), cache: NewBuildCache("")}
+
+	err := renderer.BuildFeeds(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "feeds must have at least one entry") {
+		t.Errorf("expected error for feeds without entries, got %v", err)
+	}
+}
+
+func TestFeedsStage(t *testing.T) {
+	setUpTestSite(t)
+	appendToFile(
+		"content/posts/first-post.md",
+		"\nSee [the tool](/tool), the [note](#note) and the [Go site](https://go.dev).\n",
+	)(t)
+	output := NewMemoryOutput()
+	renderTestSite(t, output, NewBuildCache(""))
+
+	var feed jsonFeed
+	if err := json.Unmarshal([]byte(readOutputFile(t, output, "feed.json")), &feed); err != nil {
+		t.Fatalf("expected JSON feed to be valid JSON: %v", err)
+	}
+	// The fixture projects have no date, so only the post is in the feed
+	if len(feed.Items) != 1 || feed.Items[0].URL != "https://example.dev/posts/first-post" {
+		t.Fatalf("expected feed with first post, got %+v", feed.Items)
+	}
+	for _, expected := range []string{
+		`href="https://example.dev/tool"`,
+		`href="https://example.dev/posts/first-post#note"`,
+		`href="https://go.dev"`,
+	} {
+		if content := feed.Items[0].ContentHTML; !strings.Contains(content, expected) {
+			t.Errorf("expected feed content to contain %q, got:\n%s", expected, content)
+		}
+	}
+
+	indexPage := readOutputFile(t, output, "index.html")
+	if !strings.Contains(indexPage, `href="https://example.dev/feed.xml"`) {
+		t.Errorf("expected index page to link to Atom feed, got:\n%s", indexPage)
+	}
+}
+
+func TestFeedsStageWithoutPosts(t *testing.T) {
+	setUpTestSite(t)
+	if err := os.Remove("content/posts/first-post.md"); err != nil {
+		t.Fatal(err)
+	}
+	output := NewMemoryOutput()
+	renderTestSite(t, output, NewBuildCache(""))
+
+	for _, feedPath := range []string{AtomFeedPath, RSSFeedPath, JSONFeedPath} {
+		if _, err := output.Open(strings.TrimPrefix(feedPath, "/")); err == nil {
+			t.Errorf("expected no feed '%s' for site without posts", feedPath)
+		}
+	}
+	if indexPage := readOutputFile(t, output, "index.html"); strings.Contains(
+		indexPage,
+		`rel="alternate"`,
+	) {
+		t.Errorf("expected index page to not link to feeds, got:\n%s", indexPage)
+	}
+}
+
+func TestAbsoluteURLsInHTML(t *testing.T) {
+	testCases := []struct {
+		name     string
+		html     template.HTML
+		expected template.HTML
+	}{
+		{
+			name:     "root-relative link",
+			html:     `<a href="/tool">Tool</a>`,
+			expected: `<a href="https://example.dev/tool">Tool</a>`,
+		},
+		{
+			name:     "root link",
+			html:     `<a href="/">Home</a>`,
+			expected: `<a href="https://example.dev/">Home</a>`,
+		},
+		{
+			name:     "fragment link",
+			html:     `<sup><a href="#fn:1">1</a></sup>`,
+			expected: `<sup><a href="https://example.dev/posts/post#fn:1">1</a></sup>`,
+		},
+		{
+			name:     "image source",
+			html:     `<img src="/img/photo.jpg" alt="Photo">`,
+			expected: `<img src="https://example.dev/img/photo.jpg" alt="Photo">`,
+		},
+		{
+			name: "srcset",
+			html: `<img srcset="/img/photo-400.jpg 400w, /img/photo-800.jpg 800w">`,
+			expected: `<img srcset="https://example.dev/img/photo-400.jpg 400w, ` +
+				`https://example.dev/img/photo-800.jpg 800w">`,
+		},
+		{
+			name:     "absolute link",
+			html:     `<a href="https://go.dev/doc">Docs</a>`,
+			expected: `<a href="https://go.dev/doc">Docs</a>`,
+		},
+		{
+			name:     "protocol-relative link",
+			html:     `<img src="//cdn.example.com/img.png">`,
+			expected: `<img src="//cdn.example.com/img.png">`,
+		},
+		{
+			name:     "URL in text",
+			html:     `<code>href="/tool"</code>`,
+			expected: `<code>href="/tool"</code>`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			html := absoluteURLsInHTML(
+				testCase.html,
+				"https://example.dev",
+				"https://example.dev/posts/post",
+			)
+			if html != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, html)
+			}
+		})
+	}
+}
+
+func testFeedEntry(title string, date time.Time) FeedEntry {
+	return FeedEntry{
+		Title:   title,
+		URL:     "https://example.dev/" + strings.ReplaceAll(strings.ToLower(title), " ", "-"),
+		Date:    date,
+		Summary: "Summary of " + title,
+		Content: template.HTML("<p>Content of " + title + "</p>"),
+		Tags:    nil,
+	}
+}
+
+func expectFeedTitles(t *testing.T, feedName string, titles []string, expected []string) {
+	t.Helper()
+
+	if !slices.Equal(titles, expected) {
+		t.Errorf("expected %s feed entries:\n%v\ngot:\n%v", feedName, expected, titles)
+	}
+}
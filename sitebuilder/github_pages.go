@@ -0,0 +1,190 @@
+package sitebuilder
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// GitHubPagesResponse describes how GitHub Pages responds to a request, see
+// [ResolveGitHubPagesPath].
+type GitHubPagesResponse struct {
+	StatusCode int
+	// File in the output that is served. Blank for redirects, and for 404 responses when the output
+	// has no 404.html.
+	FilePath string
+	// Set when StatusCode is 301.
+	RedirectPath string
+}
+
+// GitHubPagesVisit is the result of following a URL path through redirects, see
+// [VisitGitHubPagesPath].
+type GitHubPagesVisit struct {
+	// The requested path, followed by the target of each redirect.
+	Paths []string
+	// The response for the last path.
+	Response GitHubPagesResponse
+}
+
+const notFoundPageFile = "404.html"
+
+// ResolveGitHubPagesPath maps a URL path to a file in the output, with the same rules that GitHub
+// Pages uses (see [PageRenderer.renderPageWithAndWithoutTrailingSlash]):
+//   - /path/ serves path/index.html
+//   - /path serves the file at path if it exists, otherwise path.html
+//   - /path redirects to /path/ if there is no path.html, but there is a path/index.html
+//   - Anything else serves 404.html with a 404 status
+//
+// Used by the dev server, so that URLs behave locally as they do when deployed.
+func ResolveGitHubPagesPath(output fs.FS, urlPath string) GitHubPagesResponse {
+	cleanPath := path.Clean("/" + urlPath)
+	filePath := strings.TrimPrefix(cleanPath, "/")
+
+	if filePath == "" || strings.HasSuffix(urlPath, "/") {
+		indexFile := path.Join(filePath, "index.html")
+		if isFile(output, indexFile) {
+			return GitHubPagesResponse{
+				StatusCode:   http.StatusOK,
+				FilePath:     indexFile,
+				RedirectPath: "",
+			}
+		}
+		return notFoundResponse(output)
+	}
+
+	if isFile(output, filePath) {
+		return GitHubPagesResponse{StatusCode: http.StatusOK, FilePath: filePath, RedirectPath: ""}
+	}
+	if isFile(output, filePath+".html") {
+		return GitHubPagesResponse{
+			StatusCode:   http.StatusOK,
+			FilePath:     filePath + ".html",
+			RedirectPath: "",
+		}
+	}
+	if isFile(output, path.Join(filePath, "index.html")) {
+		return GitHubPagesResponse{
+			StatusCode:   http.StatusMovedPermanently,
+			FilePath:     "",
+			RedirectPath: cleanPath + "/",
+		}
+	}
+
+	return notFoundResponse(output)
+}
+
+func notFoundResponse(output fs.FS) GitHubPagesResponse {
+	response := GitHubPagesResponse{StatusCode: http.StatusNotFound, FilePath: "", RedirectPath: ""}
+	if isFile(output, notFoundPageFile) {
+		response.FilePath = notFoundPageFile
+	}
+	return response
+}
+
+// Matches the redirect in templates/components/head.html.tmpl, with some leeway for formatting.
+var metaRefreshPattern = regexp.MustCompile(
+	`(?i)<meta\s+http-equiv=["']?refresh["']?\s+content=["']\s*\d+\s*;\s*url=['"]?([^'"\s>]+)`,
+)
+
+// VisitGitHubPagesPath resolves the URL path like [ResolveGitHubPagesPath], and follows both HTTP
+// redirects and HTML redirects (<meta http-equiv="Refresh">) like a browser would, until reaching
+// a page that does not redirect. Lets us verify trailing slash and redirect behavior without
+// deploying.
+func VisitGitHubPagesPath(output fs.FS, urlPath string) (GitHubPagesVisit, error) {
+	const maxRedirects = 10
+
+	visit := GitHubPagesVisit{Paths: []string{urlPath}, Response: GitHubPagesResponse{}}
+	for {
+		currentPath := visit.Paths[len(visit.Paths)-1]
+		visit.Response = ResolveGitHubPagesPath(output, currentPath)
+
+		var nextPath string
+		switch {
+		case visit.Response.RedirectPath != "":
+			nextPath = visit.Response.RedirectPath
+		case path.Ext(visit.Response.FilePath) == ".html":
+			html, err := fs.ReadFile(output, visit.Response.FilePath)
+			if err != nil {
+				return GitHubPagesVisit{}, fmt.Errorf(
+					"failed to read '%s' for path '%s': %w",
+					visit.Response.FilePath,
+					currentPath,
+					err,
+				)
+			}
+			if match := metaRefreshPattern.FindSubmatch(html); match != nil {
+				nextPath = string(match[1])
+			}
+		}
+
+		if nextPath == "" {
+			return visit, nil
+		}
+		if slices.Contains(visit.Paths, nextPath) {
+			return GitHubPagesVisit{}, fmt.Errorf(
+				"redirect loop: %s",
+				strings.Join(append(visit.Paths, nextPath), " -> "),
+			)
+		}
+		if len(visit.Paths) > maxRedirects {
+			return GitHubPagesVisit{}, fmt.Errorf(
+				"more than %d redirects: %s",
+				maxRedirects,
+				strings.Join(visit.Paths, " -> "),
+			)
+		}
+		visit.Paths = append(visit.Paths, nextPath)
+	}
+}
+
+// VerifyPageURLs checks that every page in the report can be reached from its path on GitHub
+// Pages, and that pages with a redirect end up at the final redirect target.
+func VerifyPageURLs(output fs.FS, pages []PageReport) []error {
+	redirects := make(map[string]string, len(pages))
+	for _, page := range pages {
+		if page.RedirectPath != "" {
+			redirects[page.Path] = page.RedirectPath
+		}
+	}
+
+	var errs []error
+	for _, page := range pages {
+		// Redirect targets may redirect further (e.g. /path/ -> /path -> /other-path)
+		expectedPath := page.Path
+		for range len(pages) {
+			redirectPath, ok := redirects[expectedPath]
+			if !ok {
+				break
+			}
+			expectedPath = redirectPath
+		}
+
+		visit, err := VisitGitHubPagesPath(output, page.Path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("page '%s': %w", page.Path, err))
+			continue
+		}
+
+		finalPath := visit.Paths[len(visit.Paths)-1]
+		if visit.Response.StatusCode != http.StatusOK || finalPath != expectedPath {
+			errs = append(errs, fmt.Errorf(
+				"expected page '%s' to end up at '%s', but got status %d at '%s' (%s)",
+				page.Path,
+				expectedPath,
+				visit.Response.StatusCode,
+				finalPath,
+				strings.Join(visit.Paths, " -> "),
+			))
+		}
+	}
+	return errs
+}
+
+func isFile(output fs.FS, filePath string) bool {
+	fileInfo, err := fs.Stat(output, filePath)
+	return err == nil && !fileInfo.IsDir()
+}
@@ -0,0 +1,161 @@
+package sitebuilder
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestVisitGitHubPagesPath(t *testing.T) {
+	output := fstest.MapFS{
+		"index.html":      {Data: []byte("<p>Home</p>")},
+		"404.html":        {Data: []byte("<p>Not Found</p>")},
+		"page.html":       {Data: []byte("<p>Page</p>")},
+		"page/index.html": {Data: []byte(`<meta http-equiv="Refresh" content="0; url='/page'" />`)},
+		"dir/index.html":  {Data: []byte("<p>Directory</p>")},
+		"old.html":        {Data: []byte(`<META HTTP-EQUIV=refresh CONTENT="0;url=/page">`)},
+		"styles.css":      {Data: []byte("p { color: red; }")},
+	}
+
+	testCases := []struct {
+		path             string
+		expectedPaths    []string
+		expectedResponse GitHubPagesResponse
+	}{
+		{
+			path:             "/",
+			expectedPaths:    []string{"/"},
+			expectedResponse: okResponse("index.html"),
+		},
+		{
+			path:             "/page",
+			expectedPaths:    []string{"/page"},
+			expectedResponse: okResponse("page.html"),
+		},
+		{
+			path:             "/page/",
+			expectedPaths:    []string{"/page/", "/page"},
+			expectedResponse: okResponse("page.html"),
+		},
+		{
+			path:             "/page.html",
+			expectedPaths:    []string{"/page.html"},
+			expectedResponse: okResponse("page.html"),
+		},
+		{
+			path:             "/dir",
+			expectedPaths:    []string{"/dir", "/dir/"},
+			expectedResponse: okResponse("dir/index.html"),
+		},
+		{
+			path:             "/dir/",
+			expectedPaths:    []string{"/dir/"},
+			expectedResponse: okResponse("dir/index.html"),
+		},
+		{
+			path:             "/old",
+			expectedPaths:    []string{"/old", "/page"},
+			expectedResponse: okResponse("page.html"),
+		},
+		{
+			path:             "/styles.css",
+			expectedPaths:    []string{"/styles.css"},
+			expectedResponse: okResponse("styles.css"),
+		},
+		{
+			path:             "/missing",
+			expectedPaths:    []string{"/missing"},
+			expectedResponse: notFoundPageResponse(),
+		},
+		{
+			path:             "/missing/",
+			expectedPaths:    []string{"/missing/"},
+			expectedResponse: notFoundPageResponse(),
+		},
+		{
+			path:             "/old/",
+			expectedPaths:    []string{"/old/"},
+			expectedResponse: notFoundPageResponse(),
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.path, func(t *testing.T) {
+			visit, err := VisitGitHubPagesPath(output, testCase.path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(visit.Paths, testCase.expectedPaths) {
+				t.Errorf("expected paths %v, got %v", testCase.expectedPaths, visit.Paths)
+			}
+			if expected := testCase.expectedResponse; visit.Response != expected {
+				t.Errorf("expected response %+v, got %+v", expected, visit.Response)
+			}
+		})
+	}
+}
+
+func TestVisitGitHubPagesPathWithout404Page(t *testing.T) {
+	output := fstest.MapFS{"page.html": {Data: []byte("<p>Page</p>")}}
+
+	visit, err := VisitGitHubPagesPath(output, "/missing")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedResponse := GitHubPagesResponse{
+		StatusCode:   http.StatusNotFound,
+		FilePath:     "",
+		RedirectPath: "",
+	}
+	if visit.Response != expectedResponse {
+		t.Errorf("expected response %+v, got %+v", expectedResponse, visit.Response)
+	}
+}
+
+func TestVisitGitHubPagesPathRedirectLoop(t *testing.T) {
+	output := fstest.MapFS{
+		"a.html": {Data: []byte(`<meta http-equiv="Refresh" content="0; url='/b'" />`)},
+		"b.html": {Data: []byte(`<meta http-equiv="Refresh" content="0; url='/a'" />`)},
+	}
+
+	_, err := VisitGitHubPagesPath(output, "/a")
+	if err == nil || !strings.Contains(err.Error(), "redirect loop: /a -> /b -> /a") {
+		t.Errorf("expected redirect loop error, got %v", err)
+	}
+}
+
+func TestVerifyPageURLs(t *testing.T) {
+	output, report, _ := buildTestSite(t)
+
+	if errs := VerifyPageURLs(output, report.Pages); len(errs) != 0 {
+		t.Fatalf("expected all page URLs to resolve, got errors: %v", errs)
+	}
+
+	// Without tool.html, /tool redirects to /tool/, which redirects back to /tool
+	if err := output.Remove("tool.html"); err != nil {
+		t.Fatal(err)
+	}
+	errs := VerifyPageURLs(output, report.Pages)
+	if len(errs) == 0 {
+		t.Fatal("expected errors for page with missing output file")
+	}
+	for _, err := range errs {
+		if !strings.Contains(err.Error(), "'/tool") {
+			t.Errorf("expected errors only for /tool, got: %v", err)
+		}
+	}
+}
+
+func okResponse(filePath string) GitHubPagesResponse {
+	return GitHubPagesResponse{StatusCode: http.StatusOK, FilePath: filePath, RedirectPath: ""}
+}
+
+func notFoundPageResponse() GitHubPagesResponse {
+	return GitHubPagesResponse{
+		StatusCode:   http.StatusNotFound,
+		FilePath:     notFoundPageFile,
+		RedirectPath: "",
+	}
+}
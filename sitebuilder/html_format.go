@@ -0,0 +1,513 @@
+package sitebuilder
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// HTMLFormat selects how rendered pages are formatted before they are written, see [FormatHTML].
+type HTMLFormat string
+
+const (
+	// Indents nested block-level elements, so that diffs of the output are readable.
+	HTMLFormatPretty HTMLFormat = "pretty"
+	// Removes comments and insignificant whitespace, to make pages smaller.
+	HTMLFormatMinify HTMLFormat = "minify"
+	// Writes pages exactly as the templates produce them.
+	HTMLFormatNone HTMLFormat = "none"
+)
+
+// FormatHTML formats the given HTML document with the given format.
+//
+// Whitespace in text is collapsed, and only added or removed where the browser ignores it anyway:
+// next to block-level elements, and where there was whitespace already. Which elements are
+// block-level is decided by tag name, so this assumes that CSS does not make block-level elements
+// inline. The contents of <pre>, <textarea>, <script> and <style> elements are kept as-is.
+func FormatHTML(html []byte, format HTMLFormat) ([]byte, error) {
+	switch format {
+	case HTMLFormatNone:
+		return html, nil
+	case HTMLFormatPretty, HTMLFormatMinify:
+	default:
+		return nil, fmt.Errorf("unknown HTML format '%s'", format)
+	}
+
+	root, err := parseHTML(string(html), format == HTMLFormatMinify)
+	if err != nil {
+		return nil, err
+	}
+
+	formatter := htmlFormatter{output: bytes.Buffer{}}
+	formatter.output.Grow(len(html))
+	if format == HTMLFormatPretty {
+		markMultiline(root)
+		formatter.writeChildrenMultiline(root, 0)
+	} else {
+		formatter.writeChildrenInline(root)
+	}
+	formatter.output.WriteByte('\n')
+	return formatter.output.Bytes(), nil
+}
+
+type htmlNodeKind int
+
+const (
+	htmlElement htmlNodeKind = iota
+	// Text with whitespace collapsed, so that each run of whitespace is a single space.
+	htmlText
+	// Written exactly as it was parsed: doctypes, comments, and elements whose content must be kept
+	// as-is (see verbatimElements).
+	htmlVerbatim
+)
+
+type htmlNode struct {
+	kind htmlNodeKind
+	// Lowercase tag name for elements and verbatim elements, blank otherwise.
+	tag string
+	// Start tag for elements (with whitespace between attributes collapsed), text for other nodes.
+	text     string
+	children []*htmlNode
+	// Set by markMultiline.
+	multiline bool
+}
+
+// Elements that are block-level by default, or hidden (like the elements in <head>). Whitespace
+// next to these is not rendered, so we can add or remove it freely.
+var blockElements = map[string]struct{}{
+	"address": {}, "article": {}, "aside": {}, "base": {}, "blockquote": {}, "body": {},
+	"caption": {}, "col": {}, "colgroup": {}, "dd": {}, "details": {}, "dialog": {}, "div": {},
+	"dl": {}, "dt": {}, "fieldset": {}, "figcaption": {}, "figure": {}, "footer": {}, "form": {},
+	"h1": {}, "h2": {}, "h3": {}, "h4": {}, "h5": {}, "h6": {}, "head": {}, "header": {},
+	"hgroup": {}, "hr": {}, "html": {}, "legend": {}, "li": {}, "link": {}, "main": {}, "meta": {},
+	"nav": {}, "noscript": {}, "ol": {}, "p": {}, "pre": {}, "script": {}, "search": {},
+	"section": {}, "style": {}, "summary": {}, "table": {}, "tbody": {}, "td": {}, "template": {},
+	"tfoot": {}, "th": {}, "thead": {}, "title": {}, "tr": {}, "ul": {},
+}
+
+// Elements without end tags.
+var voidElements = map[string]struct{}{
+	"area": {}, "base": {}, "br": {}, "col": {}, "embed": {}, "hr": {}, "img": {}, "input": {},
+	"link": {}, "meta": {}, "source": {}, "track": {}, "wbr": {},
+}
+
+// Elements where whitespace is significant (<pre>, <textarea>), or whose content is not HTML
+// (<script>, <style>), so they are written exactly as they were parsed.
+var verbatimElements = map[string]struct{}{
+	"pre": {}, "script": {}, "style": {}, "textarea": {},
+}
+
+func isBlockElement(node *htmlNode) bool {
+	if node.kind == htmlText {
+		return false
+	}
+	_, ok := blockElements[node.tag]
+	return ok
+}
+
+// Parses the HTML into a tree under a root node. End tags may be omitted where HTML allows it (e.g.
+// for <li>, see closedByStartTag), but an end tag without a matching start tag is an error, since
+// it usually means that a template is broken.
+func parseHTML(html string, dropComments bool) (*htmlNode, error) {
+	root := &htmlNode{kind: htmlElement, tag: "", text: "", children: nil, multiline: false}
+	openElements := []*htmlNode{root}
+
+	addNode := func(node *htmlNode) {
+		parent := openElements[len(openElements)-1]
+		// Merges adjacent text, which happens when comments between them are dropped
+		if node.kind == htmlText && len(parent.children) != 0 {
+			if previous := parent.children[len(parent.children)-1]; previous.kind == htmlText {
+				previous.text = collapseWhitespace(previous.text + node.text)
+				return
+			}
+		}
+		parent.children = append(parent.children, node)
+	}
+
+	for len(html) != 0 {
+		tagStart := findTagStart(html)
+		if tagStart != 0 {
+			end := tagStart
+			if end == -1 {
+				end = len(html)
+			}
+			addNode(newHTMLNode(htmlText, "", collapseWhitespace(html[:end])))
+			html = html[end:]
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(html, "<!--"):
+			end := strings.Index(html, "-->")
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated comment '%s'", truncateHTML(html))
+			}
+			if !dropComments {
+				addNode(newHTMLNode(htmlVerbatim, "", html[:end+len("-->")]))
+			}
+			html = html[end+len("-->"):]
+		case strings.HasPrefix(html, "<!"):
+			end := strings.IndexByte(html, '>')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated declaration '%s'", truncateHTML(html))
+			}
+			addNode(newHTMLNode(htmlVerbatim, "!doctype", html[:end+1]))
+			html = html[end+1:]
+		case strings.HasPrefix(html, "</"):
+			end := strings.IndexByte(html, '>')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated end tag '%s'", truncateHTML(html))
+			}
+			tag := strings.ToLower(strings.TrimSpace(html[2:end]))
+
+			// Closes any elements with omitted end tags inside the one we are closing
+			matched := false
+			for i := len(openElements) - 1; i > 0; i-- {
+				if openElements[i].tag == tag {
+					openElements = openElements[:i]
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("end tag '</%s>' has no matching start tag", tag)
+			}
+			html = html[end+1:]
+		default:
+			startTag, tag, selfClosing, err := parseStartTag(html)
+			if err != nil {
+				return nil, err
+			}
+			html = html[len(startTag):]
+
+			if _, ok := verbatimElements[tag]; ok {
+				end := indexEndTag(html, tag)
+				if end == -1 {
+					return nil, fmt.Errorf("element '<%s>' has no end tag", tag)
+				}
+				endTagLength := strings.IndexByte(html[end:], '>') + 1
+				addNode(newHTMLNode(htmlVerbatim, tag, startTag+html[:end+endTagLength]))
+				html = html[end+endTagLength:]
+				continue
+			}
+
+			for len(openElements) > 1 &&
+				closedByStartTag(openElements[len(openElements)-1].tag, tag) {
+				openElements = openElements[:len(openElements)-1]
+			}
+
+			element := newHTMLNode(htmlElement, tag, normalizeStartTag(startTag))
+			addNode(element)
+			if _, isVoid := voidElements[tag]; !isVoid && !selfClosing {
+				openElements = append(openElements, element)
+			}
+		}
+	}
+
+	return root, nil
+}
+
+// Returns true if the start tag for the next element implicitly closes the current element, for
+// elements whose end tags may be omitted.
+func closedByStartTag(current string, next string) bool {
+	switch current {
+	case "p":
+		_, isBlock := blockElements[next]
+		return isBlock
+	case "li":
+		return next == "li"
+	case "dt", "dd":
+		return next == "dt" || next == "dd"
+	case "tr":
+		return next == "tr"
+	case "td", "th":
+		return next == "td" || next == "th" || next == "tr"
+	case "option":
+		return next == "option"
+	default:
+		return false
+	}
+}
+
+func newHTMLNode(kind htmlNodeKind, tag string, text string) *htmlNode {
+	return &htmlNode{kind: kind, tag: tag, text: text, children: nil, multiline: false}
+}
+
+// Returns the index of the next '<' that starts a tag, comment or declaration, or -1 if there is
+// none. A '<' that is not followed by a letter, '/' or '!' is text.
+func findTagStart(html string) int {
+	offset := 0
+	for {
+		index := strings.IndexByte(html[offset:], '<')
+		if index == -1 {
+			return -1
+		}
+		index += offset
+		if index+1 < len(html) {
+			next := html[index+1]
+			if next == '/' || next == '!' || isASCIILetter(next) {
+				return index
+			}
+		}
+		offset = index + 1
+	}
+}
+
+// Returns the start tag at the beginning of the given HTML (up to and including '>', skipping '>'
+// in quoted attribute values), along with its lowercase tag name and whether it ends with "/>".
+func parseStartTag(html string) (startTag string, tag string, selfClosing bool, err error) {
+	nameEnd := 1
+	for nameEnd < len(html) && !isHTMLSpace(html[nameEnd]) && html[nameEnd] != '>' &&
+		html[nameEnd] != '/' {
+		nameEnd++
+	}
+	tag = strings.ToLower(html[1:nameEnd])
+
+	var quote byte
+	for i := nameEnd; i < len(html); i++ {
+		switch {
+		case quote != 0:
+			if html[i] == quote {
+				quote = 0
+			}
+		case html[i] == '"' || html[i] == '\'':
+			quote = html[i]
+		case html[i] == '>':
+			startTag = html[:i+1]
+			return startTag, tag, strings.HasSuffix(startTag, "/>"), nil
+		}
+	}
+
+	return "", "", false, fmt.Errorf("unterminated start tag '%s'", truncateHTML(html))
+}
+
+// Collapses whitespace between attributes to single spaces, leaving attribute values as-is.
+func normalizeStartTag(startTag string) string {
+	var normalized strings.Builder
+	normalized.Grow(len(startTag))
+
+	var quote byte
+	pendingSpace := false
+	for i := range len(startTag) {
+		char := startTag[i]
+		if quote == 0 && isHTMLSpace(char) {
+			pendingSpace = true
+			continue
+		}
+		if pendingSpace {
+			if char != '>' {
+				normalized.WriteByte(' ')
+			}
+			pendingSpace = false
+		}
+
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '"' || char == '\'':
+			quote = char
+		}
+		normalized.WriteByte(char)
+	}
+
+	return normalized.String()
+}
+
+// Returns the index of the end tag for the given tag name (case-insensitive), or -1 if not found.
+func indexEndTag(html string, tag string) int {
+	lowerHTML := strings.ToLower(html)
+	endTag := "</" + tag
+	offset := 0
+	for {
+		index := strings.Index(lowerHTML[offset:], endTag)
+		if index == -1 {
+			return -1
+		}
+		index += offset
+		after := index + len(endTag)
+		if after < len(html) && (html[after] == '>' || isHTMLSpace(html[after])) &&
+			strings.IndexByte(html[after:], '>') != -1 {
+			return index
+		}
+		offset = after
+	}
+}
+
+func collapseWhitespace(text string) string {
+	var collapsed strings.Builder
+	collapsed.Grow(len(text))
+
+	inSpace := false
+	for i := range len(text) {
+		if isHTMLSpace(text[i]) {
+			if !inSpace {
+				collapsed.WriteByte(' ')
+				inSpace = true
+			}
+		} else {
+			collapsed.WriteByte(text[i])
+			inSpace = false
+		}
+	}
+
+	return collapsed.String()
+}
+
+func isHTMLSpace(char byte) bool {
+	return char == ' ' || char == '\t' || char == '\n' || char == '\r' || char == '\f'
+}
+
+func isASCIILetter(char byte) bool {
+	return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+}
+
+// For error messages.
+func truncateHTML(html string) string {
+	const maxLength = 50
+	if len(html) > maxLength {
+		return html[:maxLength] + "..."
+	}
+	return html
+}
+
+// Marks elements that should be written over multiple lines in pretty format: elements that
+// contain block-level or multiline elements, where line breaks can be added after the start tag
+// and before the end tag without changing how the page renders.
+func markMultiline(node *htmlNode) bool {
+	hasBlockChild := false
+	for _, child := range node.children {
+		if markMultiline(child) || isBlockElement(child) {
+			hasBlockChild = true
+		}
+	}
+	if node.kind != htmlElement || !hasBlockChild {
+		return false
+	}
+
+	// The root node has no tags, so it can always be broken into lines
+	if node.tag == "" || isBlockElement(node) {
+		node.multiline = true
+		return true
+	}
+
+	first := node.children[0]
+	last := node.children[len(node.children)-1]
+	node.multiline = (isBlockElement(first) || hasLeadingSpace(first)) &&
+		(isBlockElement(last) || hasTrailingSpace(last))
+	return node.multiline
+}
+
+func hasLeadingSpace(node *htmlNode) bool {
+	return node.kind == htmlText && strings.HasPrefix(node.text, " ")
+}
+
+func hasTrailingSpace(node *htmlNode) bool {
+	return node.kind == htmlText && strings.HasSuffix(node.text, " ")
+}
+
+type htmlFormatter struct {
+	output bytes.Buffer
+}
+
+const htmlIndent = "  "
+
+// Writes each child of the given multiline node on its own line, except inline children, which
+// are kept on the same line as adjacent inline children.
+func (formatter *htmlFormatter) writeChildrenMultiline(parent *htmlNode, depth int) {
+	lineStarted := false
+	// Whether there was whitespace between the previous child and the current one.
+	spaceBefore := false
+	// The last child that was written (skipping whitespace-only text).
+	var previous *htmlNode
+
+	for _, child := range parent.children {
+		if hasLeadingSpace(child) {
+			spaceBefore = true
+		}
+		text := strings.TrimSpace(child.text)
+		if child.kind == htmlText && text == "" {
+			continue
+		}
+
+		if lineStarted {
+			switch {
+			case isBlockElement(previous) || isBlockElement(child):
+				lineStarted = false
+			case spaceBefore && (previous.multiline || child.multiline):
+				lineStarted = false
+			case spaceBefore:
+				formatter.output.WriteByte(' ')
+			}
+		}
+		if !lineStarted {
+			formatter.startLine(depth)
+			lineStarted = true
+		}
+
+		if child.kind == htmlText {
+			formatter.output.WriteString(text)
+		} else {
+			formatter.writeNode(child, depth)
+		}
+		spaceBefore = hasTrailingSpace(child)
+		previous = child
+	}
+}
+
+func (formatter *htmlFormatter) startLine(depth int) {
+	if formatter.output.Len() != 0 {
+		formatter.output.WriteByte('\n')
+	}
+	for range depth {
+		formatter.output.WriteString(htmlIndent)
+	}
+}
+
+func (formatter *htmlFormatter) writeNode(node *htmlNode, depth int) {
+	switch node.kind {
+	case htmlText, htmlVerbatim:
+		formatter.output.WriteString(node.text)
+	case htmlElement:
+		formatter.output.WriteString(node.text)
+		if node.multiline {
+			formatter.writeChildrenMultiline(node, depth+1)
+			if len(node.children) != 0 {
+				formatter.startLine(depth)
+			}
+		} else {
+			formatter.writeChildrenInline(node)
+		}
+		if _, isVoid := voidElements[node.tag]; !isVoid && !strings.HasSuffix(node.text, "/>") {
+			// Takes the tag name from the start tag, to keep its case (which matters in SVG)
+			formatter.output.WriteString("</" + node.text[1:1+len(node.tag)] + ">")
+		}
+	}
+}
+
+// Writes the children of the given node on a single line, keeping whitespace between them as a
+// single space, except where it is not rendered (next to block-level elements).
+func (formatter *htmlFormatter) writeChildrenInline(parent *htmlNode) {
+	// Whitespace at the start and end of a block-level element is not rendered
+	trimEdges := parent.tag == "" || isBlockElement(parent)
+
+	for i, child := range parent.children {
+		if child.kind != htmlText {
+			formatter.writeNode(child, 0)
+			continue
+		}
+
+		text := child.text
+		if strings.HasPrefix(text, " ") &&
+			((i == 0 && trimEdges) || (i > 0 && isBlockElement(parent.children[i-1]))) {
+			text = text[1:]
+		}
+		if strings.HasSuffix(text, " ") &&
+			((i == len(parent.children)-1 && trimEdges) ||
+				(i < len(parent.children)-1 && isBlockElement(parent.children[i+1]))) {
+			text = text[:len(text)-1]
+		}
+		formatter.output.WriteString(text)
+	}
+}
@@ -0,0 +1,108 @@
+package sitebuilder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatHTML(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		format   HTMLFormat
+		expected string
+	}{
+		{
+			name:   "pretty indents block elements",
+			format: HTMLFormatPretty,
+			input: "<!doctype html>\n<html>\n<head>\n<title>Title</title>\n</head>\n" +
+				"<body>\n<ul>\n<li>One\n<li>Two <!-- comment --> three\n</ul>\n</body>\n</html>\n",
+			expected: "<!doctype html>\n<html>\n  <head>\n    <title>Title</title>\n  </head>\n" +
+				"  <body>\n    <ul>\n      <li>One</li>\n" +
+				"      <li>Two <!-- comment --> three</li>\n    </ul>\n  </body>\n</html>\n",
+		},
+		{
+			name:     "minify removes comments and whitespace next to block elements",
+			format:   HTMLFormatMinify,
+			input:    "<ul>\n  <li>One\n  <li>Two <!-- comment --> three\n</ul>\n",
+			expected: "<ul><li>One</li><li>Two three</li></ul>\n",
+		},
+		{
+			name:   "minify keeps whitespace between inline elements",
+			format: HTMLFormatMinify,
+			input: "<p>\n  Hello <strong>big</strong>\n  <em>world</em>!\n" +
+				"  <a href=\"/\">link</a><span>glued</span>\n</p>\n",
+			expected: "<p>Hello <strong>big</strong> <em>world</em>! " +
+				"<a href=\"/\">link</a><span>glued</span></p>\n",
+		},
+		{
+			name:     "pretty keeps whitespace between inline elements",
+			format:   HTMLFormatPretty,
+			input:    "<p>\n  Hello <strong>big</strong>\n  <em>world</em>!\n</p>\n",
+			expected: "<p>Hello <strong>big</strong> <em>world</em>!</p>\n",
+		},
+		{
+			name:   "attribute values with '>'",
+			format: HTMLFormatMinify,
+			input: "<div>\n  <a title=\"a > b\" href=\"/\">link</a>\n" +
+				"  <span data-value='1>2'>text</span>\n</div>\n",
+			expected: "<div><a title=\"a > b\" href=\"/\">link</a> " +
+				"<span data-value='1>2'>text</span></div>\n",
+		},
+		{
+			name:     "none passes through input unchanged",
+			format:   HTMLFormatNone,
+			input:    "<div>\n  <p>  Unclosed\n</span>\n<!-- comment -->",
+			expected: "<div>\n  <p>  Unclosed\n</span>\n<!-- comment -->",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			formatted, err := FormatHTML([]byte(testCase.input), testCase.format)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(formatted) != testCase.expected {
+				t.Errorf("expected:\n%q\ngot:\n%q", testCase.expected, formatted)
+			}
+		})
+	}
+}
+
+func TestFormatHTMLKeepsVerbatimElements(t *testing.T) {
+	verbatimElements := []string{
+		"<pre>\n  ___\n |   |  <b>bold</b>\n |___|\n</pre>",
+		"<textarea>  two  spaces\n\n  and blank lines </textarea>",
+		"<script>\n  if (a < b && c > d) {\n    write(\"</p>\");\n  }\n</script>",
+		"<style>\n  a > b {  color: red; }\n\n  p::before { content: \"<\"; }\n</style>",
+	}
+
+	for _, format := range []HTMLFormat{HTMLFormatPretty, HTMLFormatMinify} {
+		for _, element := range verbatimElements {
+			tag := element[1:strings.IndexAny(element, ">")]
+			t.Run(string(format)+"/"+tag, func(t *testing.T) {
+				input := "<html>\n<body>\n<div>\n  " + element + "\n</div>\n</body>\n</html>\n"
+
+				formatted, err := FormatHTML([]byte(input), format)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !strings.Contains(string(formatted), element) {
+					t.Errorf("expected output to contain:\n%q\ngot:\n%q", element, formatted)
+				}
+			})
+		}
+	}
+}
+
+func TestFormatHTMLUnmatchedEndTag(t *testing.T) {
+	for _, format := range []HTMLFormat{HTMLFormatPretty, HTMLFormatMinify} {
+		t.Run(string(format), func(t *testing.T) {
+			_, err := FormatHTML([]byte("<div><p>Text</span></p></div>"), format)
+			if err == nil {
+				t.Error("expected error for end tag without start tag")
+			}
+		})
+	}
+}
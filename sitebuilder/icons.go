@@ -1,26 +1,30 @@
 package sitebuilder
 
 import (
+	"context"
 	"fmt"
 	"html/template"
 	"os"
 
 	"golang.org/x/sync/errgroup"
 	"hermannm.dev/wrap"
+	"hermannm.dev/wrap/ctxwrap"
 )
 
 type IconMap map[string]*IconConfig
 
 type IconConfig struct {
-	Path string `validate:"required,filepath"`
-	// Populated after [PageRenderer.RenderIcons] finishes.
-	RenderedIcon          template.HTML
-	Link                  string `validate:"omitempty,url"`
-	IndexPageFallbackPath string `validate:"omitempty,filepath"`
+	// Combined icons, such as "Go+Rust", may omit Path and only set IndexPageFallbackPath.
+	Path string `yaml:"path" validate:"required_without=IndexPageFallbackPath,omitempty,filepath"`
+	// Populated after [PageRenderer.RenderIcons] finishes (signaled by the renderedIcons build
+	// output).
+	RenderedIcon          template.HTML `yaml:"-"`
+	Link                  string        `yaml:"link"                  validate:"omitempty,url"`
+	IndexPageFallbackPath string        `yaml:"indexPageFallbackPath" validate:"omitempty,filepath"`
 	// Blank if there was no index page fallback icon for this entry.
-	RenderedIndexPageFallbackIcon template.HTML
+	RenderedIndexPageFallbackIcon template.HTML `yaml:"-"`
 	// Base URL of links that this icon should be used for.
-	IconForLinks []string `validate:"omitempty,dive,url"`
+	IconForLinks []string `yaml:"iconForLinks" validate:"omitempty,dive,url"`
 }
 
 func (icons IconMap) getRenderedIcon(name string) (template.HTML, error) {
@@ -34,6 +38,24 @@ func (icons IconMap) getRenderedIcon(name string) (template.HTML, error) {
 	return icon.RenderedIcon, nil
 }
 
+var renderedIcons = NewBuildOutput[IconMap]("rendered icons")
+
+func (renderer *PageRenderer) IconsStage() BuildStage {
+	return BuildStage{
+		Name:        "render icons",
+		ContentFile: "",
+		Inputs:      nil,
+		Outputs:     []BuildOutputKey{renderedIcons},
+		Run: func(ctx context.Context, build BuildState) error {
+			if err := renderer.RenderIcons(); err != nil {
+				return ctxwrap.Error(ctx, err, "failed to render icons")
+			}
+			renderedIcons.Set(build, renderer.icons)
+			return nil
+		},
+	}
+}
+
 func (renderer *PageRenderer) RenderIcons() error {
 	var group errgroup.Group
 
@@ -69,8 +91,6 @@ func (renderer *PageRenderer) RenderIcons() error {
 	}
 	renderer.commonData.githubIcon = githubIcon
 
-	// Signals to other goroutines that icons have finished rendering
-	close(renderer.iconsRendered)
 	return nil
 }
 
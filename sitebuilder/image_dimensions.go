@@ -0,0 +1,191 @@
+package sitebuilder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"hermannm.dev/wrap"
+)
+
+// Returns the dimensions of the image at the given path, along with its format ("png", "jpeg",
+// "gif", "webp" or "svg"). For JPEGs with an EXIF orientation that rotates them by 90 degrees, the
+// width and height are swapped, since that is how the image is displayed.
+func getImageDimensions(path string) (width int, height int, format string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, "", wrap.Error(err, "failed to read image file")
+	}
+
+	switch {
+	case isWebP(data):
+		width, height, err = getWebPDimensions(data)
+		if err != nil {
+			return 0, 0, "", wrap.Error(err, "failed to read dimensions from WebP image")
+		}
+		return width, height, "webp", nil
+	case strings.EqualFold(filepath.Ext(path), ".svg"):
+		width, height, err = getSVGDimensions(data)
+		if err != nil {
+			return 0, 0, "", wrap.Error(err, "failed to read dimensions from SVG image")
+		}
+		return width, height, "svg", nil
+	default:
+		config, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return 0, 0, "", wrap.Error(err, "failed to decode config from image")
+		}
+		if format == "jpeg" && getJPEGOrientation(data) >= 5 {
+			return config.Height, config.Width, format, nil
+		}
+		return config.Width, config.Height, format, nil
+	}
+}
+
+// Scales a dimension of an image (e.g. the height) by the ratio between the new and original size
+// of its other dimension (e.g. the width), rounded to the nearest pixel.
+func scaleDimension(dimension int, newOtherDimension int, otherDimension int) int {
+	return max((dimension*newOtherDimension+otherDimension/2)/otherDimension, 1)
+}
+
+// WebP images are RIFF containers, starting with "RIFF", the file size and "WEBP".
+func isWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// The standard library has no WebP decoder, so we read the dimensions from the header of the first
+// chunk, as described in https://developers.google.com/speed/webp/docs/riff_container.
+func getWebPDimensions(data []byte) (width int, height int, err error) {
+	if len(data) < 30 {
+		return 0, 0, errors.New("file too short for WebP header")
+	}
+
+	// Chunk data starts after the 4-byte chunk type and 4-byte chunk size
+	chunkType := string(data[12:16])
+	chunk := data[20:]
+
+	switch chunkType {
+	case "VP8 ": // Lossy
+		// 3-byte frame tag, 3-byte start code, then 14-bit width and height
+		if !bytes.Equal(chunk[3:6], []byte{0x9d, 0x01, 0x2a}) {
+			return 0, 0, errors.New("invalid start code in lossy WebP frame")
+		}
+		width = int(binary.LittleEndian.Uint16(chunk[6:8]) & 0x3fff)
+		height = int(binary.LittleEndian.Uint16(chunk[8:10]) & 0x3fff)
+	case "VP8L": // Lossless
+		// 1-byte signature, then 14-bit width - 1 and height - 1
+		if chunk[0] != 0x2f {
+			return 0, 0, errors.New("invalid signature in lossless WebP frame")
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:5])
+		width = int(bits&0x3fff) + 1
+		height = int((bits>>14)&0x3fff) + 1
+	case "VP8X": // Extended (with animation, alpha or metadata)
+		// 1-byte flags, 3 reserved bytes, then 24-bit canvas width - 1 and height - 1
+		width = int(uint32(chunk[4])|uint32(chunk[5])<<8|uint32(chunk[6])<<16) + 1
+		height = int(uint32(chunk[7])|uint32(chunk[8])<<8|uint32(chunk[9])<<16) + 1
+	default:
+		return 0, 0, fmt.Errorf("unknown WebP chunk type '%s'", chunkType)
+	}
+
+	return width, height, nil
+}
+
+// Reads the dimensions of an SVG from the width and height attributes of its root element, falling
+// back to its viewBox. If only one of width and height is set, the other is scaled to the aspect
+// ratio of the viewBox.
+func getSVGDimensions(data []byte) (width int, height int, err error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return 0, 0, wrap.Error(err, "failed to find root <svg> element")
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue // Skips XML declaration, comments and doctype before the root element
+		}
+		if element.Name.Local != "svg" {
+			return 0, 0, fmt.Errorf("expected root <svg> element, got <%s>", element.Name.Local)
+		}
+
+		return getSVGElementDimensions(element)
+	}
+}
+
+func getSVGElementDimensions(svg xml.StartElement) (width int, height int, err error) {
+	var widthAttr, heightAttr, viewBoxAttr string
+	for _, attr := range svg.Attr {
+		switch attr.Name.Local {
+		case "width":
+			widthAttr = attr.Value
+		case "height":
+			heightAttr = attr.Value
+		case "viewBox":
+			viewBoxAttr = attr.Value
+		}
+	}
+
+	svgWidth, hasWidth := parseSVGLength(widthAttr)
+	svgHeight, hasHeight := parseSVGLength(heightAttr)
+
+	var viewBoxWidth, viewBoxHeight float64
+	hasViewBox := false
+	if viewBoxAttr != "" {
+		// min-x, min-y, width and height, separated by whitespace and/or commas
+		fields := strings.FieldsFunc(
+			viewBoxAttr,
+			func(char rune) bool { return char == ',' || unicode.IsSpace(char) },
+		)
+		if len(fields) != 4 {
+			return 0, 0, fmt.Errorf("invalid viewBox '%s'", viewBoxAttr)
+		}
+		viewBoxWidth, err = strconv.ParseFloat(fields[2], 64)
+		if err != nil || viewBoxWidth <= 0 {
+			return 0, 0, fmt.Errorf("invalid width in viewBox '%s'", viewBoxAttr)
+		}
+		viewBoxHeight, err = strconv.ParseFloat(fields[3], 64)
+		if err != nil || viewBoxHeight <= 0 {
+			return 0, 0, fmt.Errorf("invalid height in viewBox '%s'", viewBoxAttr)
+		}
+		hasViewBox = true
+	}
+
+	switch {
+	case hasWidth && hasHeight:
+	case hasWidth && hasViewBox:
+		svgHeight = svgWidth * viewBoxHeight / viewBoxWidth
+	case hasHeight && hasViewBox:
+		svgWidth = svgHeight * viewBoxWidth / viewBoxHeight
+	case hasViewBox:
+		svgWidth, svgHeight = viewBoxWidth, viewBoxHeight
+	default:
+		return 0, 0, errors.New(
+			"root <svg> element has no viewBox, and no width and height in pixels",
+		)
+	}
+
+	return max(int(math.Round(svgWidth)), 1), max(int(math.Round(svgHeight)), 1), nil
+}
+
+// Parses a width or height attribute on an SVG element. Only unitless and pixel lengths are
+// supported, since other units (such as percentages and ems) depend on where the SVG is used.
+func parseSVGLength(length string) (pixels float64, ok bool) {
+	length = strings.TrimSuffix(strings.TrimSpace(length), "px")
+	pixels, err := strconv.ParseFloat(length, 64)
+	if err != nil || pixels <= 0 {
+		return 0, false
+	}
+	return pixels, true
+}
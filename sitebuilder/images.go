@@ -0,0 +1,808 @@
+package sitebuilder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"hermannm.dev/wrap"
+)
+
+// ImageConfig configures the [ImagePipeline].
+type ImageConfig struct {
+	// Widths (in pixels) to generate variants of each image at. Widths larger than the source image
+	// are skipped, and a variant with the width of the source image is always generated.
+	Widths []int `yaml:"widths" validate:"required,dive,gt=0"`
+	// Quality (1-100) that JPEG images are re-encoded with. PNG images are re-encoded losslessly,
+	// since Go's PNG encoder has no quality setting.
+	JPEGQuality int `yaml:"jpegQuality" validate:"required,min=1,max=100"`
+	// The sizes attribute for images in Markdown, telling browsers how wide the images are
+	// displayed (so they can pick a variant from srcset before layout).
+	MarkdownSizes string `yaml:"markdownSizes" validate:"required"`
+	// Width (in pixels) of the placeholders that are shown while images load. Placeholders are
+	// inlined in pages, so they should be tiny: the browser scales them up, which blurs them.
+	PlaceholderWidth int `yaml:"placeholderWidth" validate:"required,gt=0"`
+}
+
+// Bump when changing how image variants are generated, to regenerate variants that are cached from
+// previous builds.
+const imagePipelineVersion = 2
+
+// ImagePipeline generates resized variants of images in [BaseAssetsDir] and page bundles for use in
+// srcset attributes, with metadata (such as EXIF location data) stripped. The image at its original
+// output path is replaced by a copy with metadata stripped as well, so that the original is never
+// published. Variants are only generated for images that are referenced in content, and are cached
+// between builds: an image is only decoded if one of its variants is missing or outdated.
+//
+// It also creates a tiny placeholder for each image, which pages show as the background of the
+// image until it has loaded. The placeholder is scaled down from the smallest variant, which is
+// cheap to decode on every build.
+//
+// Only PNG and JPEG are resized, since we only use the standard library's image packages. GIF,
+// WebP and SVG images are used as-is, with a single variant at their original size. This also
+// means that variants are not converted to more efficient formats like WebP or AVIF.
+type ImagePipeline struct {
+	config ImageConfig
+	assets *AssetManifest
+	output OutputFS
+	cache  *BuildCache
+	// If false, variant paths and dimensions are planned, but variants and placeholders are not
+	// created. Used when checking content, where encoding images would be too slow.
+	encode bool
+	// Images may be referenced by several pages rendered in parallel, so we keep track of
+	// processing jobs to only process each image once.
+	jobs map[string]*imageJob
+	lock sync.Mutex
+}
+
+type imageJob struct {
+	// Closed when image and err are set.
+	done  chan struct{}
+	image ProcessedImage
+	err   error
+}
+
+// ProcessedImage is an image that has been through the [ImagePipeline].
+type ProcessedImage struct {
+	// Dimensions of the source image, as displayed (see [getImageDimensions]).
+	Width  int
+	Height int
+	// Sorted by ascending width. The last variant has the same width as the source image.
+	Variants []ImageVariant
+	// Data URI of a tiny version of the image, to show while the image loads. Blank for images
+	// that are not resized, and when encoding is disabled.
+	Placeholder string
+}
+
+type ImageVariant struct {
+	// Path in the output, starting with a slash.
+	Path   string
+	Width  int
+	Height int
+}
+
+func NewImagePipeline(
+	config ImageConfig,
+	assets *AssetManifest,
+	output OutputFS,
+	cache *BuildCache,
+	encode bool,
+) *ImagePipeline {
+	return &ImagePipeline{
+		config: config,
+		assets: assets,
+		output: output,
+		cache:  cache,
+		encode: encode,
+		jobs:   make(map[string]*imageJob),
+		lock:   sync.Mutex{},
+	}
+}
+
+// Path returns the path of the full-size variant, to use for src attributes and links to the
+// image.
+func (image ProcessedImage) Path() string {
+	return image.Variants[len(image.Variants)-1].Path
+}
+
+// SrcSet returns the value for a srcset attribute, listing all variants with their widths.
+func (image ProcessedImage) SrcSet() string {
+	var srcSet strings.Builder
+	for i, variant := range image.Variants {
+		if i != 0 {
+			srcSet.WriteString(", ")
+		}
+		fmt.Fprintf(&srcSet, "%s %dw", variant.Path, variant.Width)
+	}
+	return srcSet.String()
+}
+
+// PlaceholderStyle returns inline CSS that shows the placeholder as the background of the image
+// element, or a blank string if the image has no placeholder. The background should be removed
+// when the image has loaded, so that it does not show through transparent parts of the image.
+func (image ProcessedImage) PlaceholderStyle() template.CSS {
+	if image.Placeholder == "" {
+		return ""
+	}
+	return template.CSS(
+		fmt.Sprintf(
+			"background-image:url(%s);background-size:cover;background-position:center",
+			image.Placeholder,
+		),
+	)
+}
+
+// Inline onload handler for images with a [ProcessedImage.PlaceholderStyle].
+const removePlaceholderScript = "this.style.removeProperty('background-image')"
+
+// Process generates variants for the image at the given asset path (e.g.
+// "/img/screenshots/gadd.png"), or reuses them from the previous build if the image and the
+// pipeline config are unchanged.
+func (pipeline *ImagePipeline) Process(assetPath string) (ProcessedImage, error) {
+	pipeline.lock.Lock()
+	job, ok := pipeline.jobs[assetPath]
+	if !ok {
+		job = &imageJob{done: make(chan struct{}), image: ProcessedImage{}, err: nil}
+		pipeline.jobs[assetPath] = job
+	}
+	pipeline.lock.Unlock()
+
+	if ok {
+		<-job.done
+	} else {
+		job.image, job.err = pipeline.processImage(assetPath)
+		close(job.done)
+	}
+
+	if job.err != nil {
+		return ProcessedImage{}, wrap.Errorf(job.err, "failed to process image '%s'", assetPath)
+	}
+	return job.image, nil
+}
+
+func (pipeline *ImagePipeline) processImage(assetPath string) (ProcessedImage, error) {
+	asset, err := pipeline.assets.source(assetPath)
+	if err != nil {
+		return ProcessedImage{}, err
+	}
+
+	width, height, format, err := getImageDimensions(asset.sourcePath)
+	if err != nil {
+		return ProcessedImage{}, wrap.Error(err, "failed to get image dimensions")
+	}
+	if format != "png" && format != "jpeg" {
+		// We can only decode and encode PNG and JPEG with the standard library, so other formats
+		// are used as-is, from the path that the image is copied to with the other static assets
+		outputPath, err := pipeline.assets.Path(assetPath)
+		if err != nil {
+			return ProcessedImage{}, err
+		}
+		return ProcessedImage{
+			Width:       width,
+			Height:      height,
+			Variants:    []ImageVariant{{Path: outputPath, Width: width, Height: height}},
+			Placeholder: "",
+		}, nil
+	}
+
+	processed := ProcessedImage{Width: width, Height: height, Variants: nil, Placeholder: ""}
+	var staleVariants []ImageVariant
+	var staleHashes []string
+	for _, variantWidth := range pipeline.variantWidths(width) {
+		inputHash := pipeline.hashVariantInputs(asset, format, variantWidth)
+
+		plainPath := strings.TrimPrefix(asset.plainPath, "/")
+		outputPath := fmt.Sprintf(
+			"%s.%dw%s",
+			strings.TrimSuffix(plainPath, path.Ext(plainPath)),
+			variantWidth,
+			path.Ext(plainPath),
+		)
+		if pipeline.assets.fingerprint {
+			outputPath = fingerprintedPath(outputPath, inputHash)
+		}
+
+		variant := ImageVariant{
+			Path:   "/" + outputPath,
+			Width:  variantWidth,
+			Height: scaleDimension(height, variantWidth, width),
+		}
+		processed.Variants = append(processed.Variants, variant)
+
+		if !pipeline.encode {
+			continue
+		}
+		if pipeline.cache.isFresh(pipeline.output, outputPath, inputHash) {
+			pipeline.cache.recordOutput(outputPath, inputHash, false)
+		} else {
+			staleVariants = append(staleVariants, variant)
+			staleHashes = append(staleHashes, inputHash)
+		}
+	}
+
+	if !pipeline.encode {
+		return processed, nil
+	}
+
+	if err := pipeline.writeStrippedOriginal(assetPath, asset, format); err != nil {
+		return ProcessedImage{}, err
+	}
+
+	if len(staleVariants) != 0 {
+		err := pipeline.writeVariants(asset.sourcePath, format, width, staleVariants, staleHashes)
+		if err != nil {
+			return ProcessedImage{}, err
+		}
+	}
+
+	processed.Placeholder, err = pipeline.createPlaceholder(processed.Variants[0])
+	if err != nil {
+		return ProcessedImage{}, wrap.Error(err, "failed to create placeholder")
+	}
+
+	return processed, nil
+}
+
+func (pipeline *ImagePipeline) writeVariants(
+	sourceFile string,
+	format string,
+	sourceWidth int,
+	variants []ImageVariant,
+	inputHashes []string,
+) error {
+	sourceData, err := os.ReadFile(sourceFile)
+	if err != nil {
+		return wrap.Error(err, "failed to read image file")
+	}
+	// Decoded on first use, since variants with the source width may not need it
+	var source *decodedImage
+
+	for i, variant := range variants {
+		outputPath := strings.TrimPrefix(variant.Path, "/")
+
+		var encoded []byte
+		if format == "png" && variant.Width == sourceWidth {
+			// Our PNGs are optimized before they are checked in, so re-encoding them would only
+			// make them larger
+			encoded, err = stripPNGMetadata(sourceData)
+		} else {
+			if source == nil {
+				source, err = decodeImage(sourceData)
+				if err != nil {
+					return err
+				}
+			}
+			encoded, err = pipeline.encodeVariant(source, format, variant)
+		}
+		if err != nil {
+			return wrap.Errorf(err, "failed to encode %dpx wide variant", variant.Width)
+		}
+
+		if err := pipeline.output.MkdirAll(path.Dir(outputPath)); err != nil {
+			return err
+		}
+		if err := pipeline.output.WriteFile(outputPath, encoded); err != nil {
+			return err
+		}
+
+		pipeline.cache.recordOutput(outputPath, inputHashes[i], true)
+	}
+
+	return nil
+}
+
+// Writes the image to its output path in the [AssetManifest] with metadata stripped, in place of
+// the copy of the source file that [PageRenderer.CopyAssets] would otherwise write. The image may
+// still be linked by that path (e.g. with the "asset" template function).
+func (pipeline *ImagePipeline) writeStrippedOriginal(
+	assetPath string,
+	asset assetFile,
+	format string,
+) error {
+	outputPath := strings.TrimPrefix(asset.outputPath, "/")
+
+	hasher := sha256.New()
+	_, _ = fmt.Fprintln(hasher, imagePipelineVersion, "original", asset.contentHash)
+	inputHash := hex.EncodeToString(hasher.Sum(nil))
+
+	if pipeline.cache.isFresh(pipeline.output, outputPath, inputHash) {
+		pipeline.cache.recordOutput(outputPath, inputHash, false)
+		pipeline.assets.markProcessed(assetPath)
+		return nil
+	}
+
+	sourceData, err := os.ReadFile(asset.sourcePath)
+	if err != nil {
+		return wrap.Error(err, "failed to read image file")
+	}
+
+	var stripped []byte
+	if format == "png" {
+		stripped, err = stripPNGMetadata(sourceData)
+	} else {
+		stripped, err = stripJPEGMetadata(sourceData)
+	}
+	if err != nil {
+		return wrap.Error(err, "failed to strip metadata from image")
+	}
+
+	if dir := path.Dir(outputPath); dir != "." {
+		if err := pipeline.output.MkdirAll(dir); err != nil {
+			return err
+		}
+	}
+	if err := pipeline.output.WriteFile(outputPath, stripped); err != nil {
+		return err
+	}
+
+	pipeline.cache.recordOutput(outputPath, inputHash, true)
+	pipeline.assets.markProcessed(assetPath)
+	return nil
+}
+
+// Returns the configured widths that are smaller than the source image, followed by the width of
+// the source image.
+func (pipeline *ImagePipeline) variantWidths(sourceWidth int) []int {
+	var widths []int
+	for _, width := range pipeline.config.Widths {
+		if width < sourceWidth && !slices.Contains(widths, width) {
+			widths = append(widths, width)
+		}
+	}
+	slices.Sort(widths)
+	return append(widths, sourceWidth)
+}
+
+func (pipeline *ImagePipeline) hashVariantInputs(
+	asset assetFile,
+	format string,
+	width int,
+) string {
+	hasher := sha256.New()
+	_, _ = fmt.Fprintln(hasher, imagePipelineVersion, asset.contentHash, width)
+	if format == "jpeg" {
+		_, _ = fmt.Fprintln(hasher, pipeline.config.JPEGQuality)
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+func (pipeline *ImagePipeline) encodeVariant(
+	source *decodedImage,
+	format string,
+	variant ImageVariant,
+) ([]byte, error) {
+	resized := resizeImage(source.pixels, variant.Width, variant.Height)
+
+	var encoded bytes.Buffer
+	if format == "jpeg" {
+		options := jpeg.Options{Quality: pipeline.config.JPEGQuality}
+		if err := jpeg.Encode(&encoded, resized, &options); err != nil {
+			return nil, err
+		}
+		return encoded.Bytes(), nil
+	}
+
+	var encodable image.Image = resized
+	// Our PNGs are usually quantized to a palette to reduce their size, so we keep the palette to
+	// not make variants larger than they need to be
+	if source.palette != nil {
+		encodable = quantizeImage(resized, source.palette)
+	}
+	if err := png.Encode(&encoded, encodable); err != nil {
+		return nil, err
+	}
+	return encoded.Bytes(), nil
+}
+
+// Scales down the given variant to the configured placeholder width, and encodes it as a PNG data
+// URI. We use PNG, since it keeps transparency, and has less overhead than JPEG for tiny images.
+func (pipeline *ImagePipeline) createPlaceholder(variant ImageVariant) (string, error) {
+	variantData, err := fs.ReadFile(pipeline.output, strings.TrimPrefix(variant.Path, "/"))
+	if err != nil {
+		return "", wrap.Errorf(err, "failed to read %dpx wide variant", variant.Width)
+	}
+
+	decoded, err := decodeImage(variantData)
+	if err != nil {
+		return "", err
+	}
+
+	width := min(pipeline.config.PlaceholderWidth, variant.Width)
+	height := scaleDimension(variant.Height, width, variant.Width)
+	placeholder := resizeImage(decoded.pixels, width, height)
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, placeholder); err != nil {
+		return "", wrap.Error(err, "failed to encode placeholder")
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(encoded.Bytes()), nil
+}
+
+// decodedImage is a source image, converted to RGBA for resizing, and rotated to be upright if it
+// is a JPEG with an EXIF orientation.
+type decodedImage struct {
+	pixels *image.RGBA
+	// Set if the source image uses a palette.
+	palette color.Palette
+}
+
+func decodeImage(data []byte) (*decodedImage, error) {
+	source, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, wrap.Error(err, "failed to decode image")
+	}
+
+	bounds := source.Bounds()
+	decoded := &decodedImage{
+		pixels:  image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy())),
+		palette: nil,
+	}
+	draw.Draw(decoded.pixels, decoded.pixels.Bounds(), source, bounds.Min, draw.Src)
+	if paletted, ok := source.(*image.Paletted); ok {
+		decoded.palette = paletted.Palette
+	}
+	if format == "jpeg" {
+		decoded.pixels = orientImage(decoded.pixels, getJPEGOrientation(data))
+	}
+
+	return decoded, nil
+}
+
+// Downscales the image to the given size by averaging the source pixels covered by each target
+// pixel (a box filter), which gives smooth results when downscaling. The standard library has no
+// image resampling, and this avoids a dependency for it.
+func resizeImage(source *image.RGBA, width int, height int) *image.RGBA {
+	sourceWidth, sourceHeight := source.Bounds().Dx(), source.Bounds().Dy()
+	if width == sourceWidth && height == sourceHeight {
+		return source
+	}
+
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		startY := y * sourceHeight / height
+		endY := max((y+1)*sourceHeight/height, startY+1)
+
+		for x := range width {
+			startX := x * sourceWidth / width
+			endX := max((x+1)*sourceWidth/width, startX+1)
+
+			var sum [4]int
+			for sourceY := startY; sourceY < endY; sourceY++ {
+				row := source.Pix[sourceY*source.Stride:]
+				for sourceX := startX; sourceX < endX; sourceX++ {
+					pixel := row[sourceX*4 : sourceX*4+4]
+					for channel := range sum {
+						sum[channel] += int(pixel[channel])
+					}
+				}
+			}
+
+			count := (endY - startY) * (endX - startX)
+			target := resized.Pix[y*resized.Stride+x*4:]
+			for channel := range sum {
+				target[channel] = uint8((sum[channel] + count/2) / count)
+			}
+		}
+	}
+
+	return resized
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// PNG chunks that affect how the image is displayed. Other chunks (such as text, EXIF data and
+// timestamps) are metadata, which we strip.
+var pngImageChunks = []string{
+	"IHDR",
+	"PLTE",
+	"tRNS",
+	"gAMA",
+	"cHRM",
+	"sRGB",
+	"iCCP",
+	"IDAT",
+	"IEND",
+}
+
+// Removes metadata chunks from the given PNG, without re-encoding it.
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("missing PNG signature")
+	}
+
+	stripped := make([]byte, 0, len(data))
+	stripped = append(stripped, pngSignature...)
+
+	// Each chunk consists of a 4-byte length, a 4-byte type, the chunk data and a 4-byte checksum
+	chunks := data[len(pngSignature):]
+	for len(chunks) != 0 {
+		if len(chunks) < 12 {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		dataLength := binary.BigEndian.Uint32(chunks[:4])
+		if uint64(dataLength) > uint64(len(chunks)-12) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		chunkLength := 12 + int(dataLength)
+
+		if slices.Contains(pngImageChunks, string(chunks[4:8])) {
+			stripped = append(stripped, chunks[:chunkLength]...)
+		}
+		chunks = chunks[chunkLength:]
+	}
+
+	return stripped, nil
+}
+
+// JPEG markers for segments that affect how the image is displayed: APP0 (JFIF), APP2 (ICC color
+// profile) and APP14 (Adobe color transform). Other application segments (such as EXIF and XMP
+// data in APP1) and comments are metadata, which we strip, except for the EXIF orientation (see
+// [exifOrientationSegment]). Segments that are not application segments or comments are part of
+// the image, and are always kept.
+var jpegImageAppMarkers = []byte{0xE0, 0xE2, 0xEE}
+
+const (
+	jpegStartOfImage = 0xD8
+	jpegStartOfScan  = 0xDA
+	jpegComment      = 0xFE
+	jpegAppEXIF      = 0xE1
+)
+
+// Removes metadata segments from the given JPEG, without re-encoding it.
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	segments, scan, err := splitJPEGSegments(data)
+	if err != nil {
+		return nil, err
+	}
+
+	stripped := make([]byte, 0, len(data))
+	stripped = append(stripped, 0xFF, jpegStartOfImage)
+
+	for _, segment := range segments {
+		isAppSegment := segment.marker >= 0xE0 && segment.marker <= 0xEF
+		isMetadata := segment.marker == jpegComment ||
+			(isAppSegment && !slices.Contains(jpegImageAppMarkers, segment.marker))
+		if !isMetadata {
+			stripped = append(stripped, 0xFF, segment.marker)
+			stripped = append(stripped, segment.content...)
+			continue
+		}
+
+		// Phone cameras store photos as they were captured, and rely on the orientation to display
+		// them upright, so we must keep it
+		if segment.marker == jpegAppEXIF {
+			if orientation := parseEXIFOrientation(segment.content); orientation != 1 {
+				stripped = append(stripped, 0xFF, jpegAppEXIF)
+				stripped = append(stripped, exifOrientationSegment(orientation)...)
+			}
+		}
+	}
+
+	// The scan is followed by entropy-coded image data, which we copy as-is
+	stripped = append(stripped, 0xFF, jpegStartOfScan)
+	return append(stripped, scan...), nil
+}
+
+type jpegSegment struct {
+	marker byte
+	// The 2-byte segment length (which includes the length itself) followed by the segment data.
+	// Nil for markers without a segment.
+	content []byte
+}
+
+// Splits the given JPEG into the segments between the start of image marker and the start of scan
+// marker, and the rest of the file after the start of scan marker.
+func splitJPEGSegments(data []byte) (segments []jpegSegment, scan []byte, err error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != jpegStartOfImage {
+		return nil, nil, errors.New("missing JPEG start of image marker")
+	}
+
+	remaining := data[2:]
+	for {
+		// Markers start with 0xFF, and may be padded with any number of 0xFF fill bytes
+		if len(remaining) == 0 || remaining[0] != 0xFF {
+			return nil, nil, errors.New("invalid JPEG marker")
+		}
+		for len(remaining) != 0 && remaining[0] == 0xFF {
+			remaining = remaining[1:]
+		}
+		if len(remaining) == 0 {
+			return nil, nil, errors.New("missing JPEG start of scan marker")
+		}
+		marker := remaining[0]
+		remaining = remaining[1:]
+
+		if marker == jpegStartOfScan {
+			return segments, remaining, nil
+		}
+
+		// Markers without a segment: TEM and RST0-7 (which should only be in scan data)
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			segments = append(segments, jpegSegment{marker: marker, content: nil})
+			continue
+		}
+
+		if len(remaining) < 2 {
+			return nil, nil, errors.New("truncated JPEG segment")
+		}
+		segmentLength := int(binary.BigEndian.Uint16(remaining[:2]))
+		if segmentLength < 2 || segmentLength > len(remaining) {
+			return nil, nil, errors.New("truncated JPEG segment")
+		}
+
+		segments = append(segments, jpegSegment{marker: marker, content: remaining[:segmentLength]})
+		remaining = remaining[segmentLength:]
+	}
+}
+
+// EXIF tag for how the image should be rotated and flipped when displayed, from 1 (as stored) to 8.
+// See https://exiftool.org/TagNames/EXIF.html for the values.
+const exifOrientationTag = 0x0112
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// Returns the EXIF orientation of the given JPEG, or 1 (as stored) if it has none.
+func getJPEGOrientation(data []byte) int {
+	segments, _, err := splitJPEGSegments(data)
+	if err != nil {
+		return 1
+	}
+	for _, segment := range segments {
+		if segment.marker == jpegAppEXIF {
+			if orientation := parseEXIFOrientation(segment.content); orientation != 1 {
+				return orientation
+			}
+		}
+	}
+	return 1
+}
+
+// Reads the orientation from the first image file directory of the given APP1 segment content.
+// Returns 1 (as stored) if the segment is not EXIF data, or has no valid orientation.
+func parseEXIFOrientation(segmentContent []byte) int {
+	if len(segmentContent) < 2 {
+		return 1
+	}
+	tiff, isEXIF := bytes.CutPrefix(segmentContent[2:], exifHeader)
+	if !isEXIF || len(tiff) < 8 {
+		return 1
+	}
+
+	// EXIF data is a TIFF file, which starts with its byte order, the number 42 and the offset of
+	// the first image file directory
+	var byteOrder binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		byteOrder = binary.LittleEndian
+	case "MM":
+		byteOrder = binary.BigEndian
+	default:
+		return 1
+	}
+	directoryOffset := uint64(byteOrder.Uint32(tiff[4:8]))
+	if directoryOffset+2 > uint64(len(tiff)) {
+		return 1
+	}
+
+	// The directory has a 2-byte entry count, followed by 12-byte entries: a 2-byte tag, 2-byte
+	// type, 4-byte value count and a 4-byte value (or offset to the value, if it does not fit)
+	entryCount := int(byteOrder.Uint16(tiff[directoryOffset:]))
+	entries := tiff[directoryOffset+2:]
+	for i := range entryCount {
+		if len(entries) < (i+1)*12 {
+			break
+		}
+		entry := entries[i*12 : (i+1)*12]
+		if byteOrder.Uint16(entry[:2]) != exifOrientationTag {
+			continue
+		}
+		if orientation := int(byteOrder.Uint16(entry[8:10])); orientation >= 1 && orientation <= 8 {
+			return orientation
+		}
+		return 1
+	}
+	return 1
+}
+
+// Returns the length and data of an APP1 segment with EXIF data that only has the given
+// orientation. We keep this when stripping metadata from JPEGs, since browsers apply it when
+// displaying the image.
+func exifOrientationSegment(orientation int) []byte {
+	const segmentLength = 34
+
+	segment := make([]byte, 0, segmentLength)
+	segment = binary.BigEndian.AppendUint16(segment, segmentLength)
+	segment = append(segment, exifHeader...)
+	// Big-endian TIFF header, with the image file directory right after it
+	segment = append(segment, "MM\x00\x2A\x00\x00\x00\x08"...)
+	// Directory with a single entry: the orientation as a 2-byte integer (type 3), padded to 4
+	// bytes, followed by the offset of the next directory (0, since there is none)
+	segment = binary.BigEndian.AppendUint16(segment, 1)
+	segment = binary.BigEndian.AppendUint16(segment, exifOrientationTag)
+	segment = binary.BigEndian.AppendUint16(segment, 3)
+	segment = binary.BigEndian.AppendUint32(segment, 1)
+	segment = binary.BigEndian.AppendUint16(segment, uint16(orientation))
+	segment = append(segment, 0, 0)
+	segment = binary.BigEndian.AppendUint32(segment, 0)
+	return segment
+}
+
+// Rotates and flips the image according to the given EXIF orientation, so that it is upright.
+func orientImage(source *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return source
+	}
+
+	sourceWidth, sourceHeight := source.Bounds().Dx(), source.Bounds().Dy()
+	width, height := sourceWidth, sourceHeight
+	// Orientations 5-8 are rotated by 90 degrees
+	if orientation >= 5 {
+		width, height = sourceHeight, sourceWidth
+	}
+
+	oriented := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			var sourceX, sourceY int
+			switch orientation {
+			case 2: // Flipped horizontally
+				sourceX, sourceY = sourceWidth-1-x, y
+			case 3: // Rotated 180 degrees
+				sourceX, sourceY = sourceWidth-1-x, sourceHeight-1-y
+			case 4: // Flipped vertically
+				sourceX, sourceY = x, sourceHeight-1-y
+			case 5: // Flipped over the top-left to bottom-right diagonal
+				sourceX, sourceY = y, x
+			case 6: // Needs to be rotated 90 degrees clockwise
+				sourceX, sourceY = y, sourceHeight-1-x
+			case 7: // Flipped over the top-right to bottom-left diagonal
+				sourceX, sourceY = sourceWidth-1-y, sourceHeight-1-x
+			case 8: // Needs to be rotated 90 degrees counter-clockwise
+				sourceX, sourceY = sourceWidth-1-y, x
+			}
+
+			sourceOffset := sourceY*source.Stride + sourceX*4
+			copy(oriented.Pix[y*oriented.Stride+x*4:], source.Pix[sourceOffset:sourceOffset+4])
+		}
+	}
+
+	return oriented
+}
+
+// Maps each pixel to the nearest color in the palette. Unlike [draw.FloydSteinberg], this does not
+// dither, but it is much faster, since resized images mostly repeat the same colors, so we can
+// cache the palette lookups. Dithering also makes little difference for screenshots, where most
+// areas have flat colors.
+func quantizeImage(source *image.RGBA, palette color.Palette) *image.Paletted {
+	quantized := image.NewPaletted(source.Bounds(), palette)
+	indexCache := make(map[[4]uint8]uint8)
+
+	for i := 0; i < len(source.Pix); i += 4 {
+		pixel := [4]uint8(source.Pix[i : i+4])
+		index, ok := indexCache[pixel]
+		if !ok {
+			pixelColor := color.RGBA{R: pixel[0], G: pixel[1], B: pixel[2], A: pixel[3]}
+			index = uint8(palette.Index(pixelColor))
+			indexCache[pixel] = index
+		}
+		quantized.Pix[i/4] = index
+	}
+
+	return quantized
+}
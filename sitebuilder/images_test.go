@@ -0,0 +1,136 @@
+package sitebuilder
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImagePipelineAppliesJPEGOrientation(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	// A landscape photo with a red left half and a blue right half, as stored by a phone camera
+	// held upright: the EXIF orientation says to rotate it 90 degrees clockwise, so that it is
+	// displayed as a portrait photo with a red top half and blue bottom half
+	stored := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := range 16 {
+		for x := range 32 {
+			if x < 16 {
+				stored.Set(x, y, color.RGBA{R: 255, G: 0, B: 0, A: 255})
+			} else {
+				stored.Set(x, y, color.RGBA{R: 0, G: 0, B: 255, A: 255})
+			}
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, stored, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	photo := append([]byte{0xFF, jpegStartOfImage}, littleEndianEXIFSegment()...)
+	photo = append(photo, encoded.Bytes()[2:]...)
+
+	if err := os.MkdirAll(filepath.Join(BaseAssetsDir, "img"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	photoPath := filepath.Join(BaseAssetsDir, "img", "photo.jpg")
+	if err := os.WriteFile(photoPath, photo, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	assets, err := LoadAssetManifest(ctx, "styles.css", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := NewMemoryOutput()
+	config := ImageConfig{
+		Widths:           []int{8},
+		JPEGQuality:      90,
+		MarkdownSizes:    "100vw",
+		PlaceholderWidth: 4,
+	}
+	pipeline := NewImagePipeline(config, assets, output, NewBuildCache(""), true)
+
+	processed, err := pipeline.Process("/img/photo.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processed.Width != 16 || processed.Height != 32 {
+		t.Errorf(
+			"expected portrait dimensions 16x32, got %dx%d",
+			processed.Width,
+			processed.Height,
+		)
+	}
+
+	for _, variant := range processed.Variants {
+		variantData := readOutputFile(t, output, strings.TrimPrefix(variant.Path, "/"))
+		decoded, err := jpeg.Decode(strings.NewReader(variantData))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bounds := decoded.Bounds()
+		if bounds.Dx() != variant.Width || bounds.Dy() != variant.Height {
+			t.Errorf(
+				"expected variant '%s' to be %dx%d, got %dx%d",
+				variant.Path,
+				variant.Width,
+				variant.Height,
+				bounds.Dx(),
+				bounds.Dy(),
+			)
+		}
+		if top := decoded.At(bounds.Dx()/2, 0); !isMostlyRed(top) {
+			t.Errorf("expected top of variant '%s' to be red, got %v", variant.Path, top)
+		}
+		if bottom := decoded.At(bounds.Dx()/2, bounds.Dy()-1); isMostlyRed(bottom) {
+			t.Errorf("expected bottom of variant '%s' to be blue, got %v", variant.Path, bottom)
+		}
+	}
+
+	// The original keeps its stored pixels, so the orientation must be kept for browsers to
+	// display it upright, while the rest of the EXIF data is stripped
+	original, err := fs.ReadFile(output, "img/photo.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(original)); err != nil {
+		t.Errorf("expected original to be a valid JPEG: %v", err)
+	}
+	if orientation := getJPEGOrientation(original); orientation != 6 {
+		t.Errorf("expected original to keep orientation 6, got %d", orientation)
+	}
+	if bytes.Contains(original, []byte("Phone")) {
+		t.Error("expected camera make to be stripped from original")
+	}
+}
+
+// Returns an APP1 segment with little-endian EXIF data, with the camera make ("Phone") and
+// orientation 6 (rotate 90 degrees clockwise).
+func littleEndianEXIFSegment() []byte {
+	exif := []byte("Exif\x00\x00")
+	exif = append(exif, "II\x2A\x00\x08\x00\x00\x00"...)
+	// Image file directory with 2 entries, where the make is stored after the directory
+	exif = append(exif, 0x02, 0x00)
+	exif = append(exif, 0x0F, 0x01, 0x02, 0x00, 0x06, 0x00, 0x00, 0x00, 0x26, 0x00, 0x00, 0x00)
+	exif = append(exif, 0x12, 0x01, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00)
+	exif = append(exif, 0x00, 0x00, 0x00, 0x00)
+	exif = append(exif, "Phone\x00"...)
+
+	segmentLength := len(exif) + 2
+	segment := []byte{0xFF, jpegAppEXIF, byte(segmentLength >> 8), byte(segmentLength)}
+	return append(segment, exif...)
+}
+
+func isMostlyRed(pixel color.Color) bool {
+	r, g, b, _ := pixel.RGBA()
+	return r > 0xC000 && g < 0x4000 && b < 0x4000
+}
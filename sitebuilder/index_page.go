@@ -58,64 +58,197 @@ type ProjectGroupTemplate struct {
 	Projects  []ProjectProfile
 }
 
+// Image is an image in frontmatter, which goes through the [ImagePipeline].
 type Image struct {
-	Path   string `yaml:"path"   validate:"required,filepath"`
-	Alt    string `yaml:"alt"    validate:"required"`
-	Width  int    `yaml:"width"  validate:"required"`
-	Height int    `yaml:"height" validate:"required"`
+	// Replaced by the path of the full-size variant after parsing.
+	Path string `yaml:"path" validate:"required,filepath"`
+	Alt  string `yaml:"alt"  validate:"required"`
+	// Optional overrides for the width and height attributes, which otherwise default to the
+	// dimensions of the image file. If only one is set, the other is scaled to the aspect ratio of
+	// the file. If both are set, they must match the aspect ratio of the file.
+	Width  int `yaml:"width"  validate:"omitempty,gt=0"`
+	Height int `yaml:"height" validate:"omitempty,gt=0"`
+	// The sizes attribute, telling browsers how wide the image is displayed. Defaults to
+	// defaultImageSizes.
+	Sizes string `yaml:"sizes"`
+	// Populated by the [ImagePipeline] after parsing.
+	SrcSet           string       `yaml:"-"`
+	PlaceholderStyle template.CSS `yaml:"-"`
 }
 
-func (renderer *PageRenderer) RenderIndexPage(ctx context.Context, contentPath string) (err error) {
-	content, aboutMeText, err := parseIndexPageContent(ctx, contentPath)
+const defaultImageSizes = "100vw"
+
+func (image *Image) process(images *ImagePipeline) error {
+	processed, err := images.Process(image.Path)
 	if err != nil {
-		return ctxwrap.Error(ctx, err, "failed to parse index page data")
+		return err
 	}
-	content.Page.SetCanonicalURL(renderer.commonData.BaseURL)
 
-	projectGroups, err := parseProjectGroups(content.ProjectGroups)
-	if err != nil {
-		return ctxwrap.Error(ctx, err, "failed to parse project groups")
+	if err := image.setDimensions(processed.Width, processed.Height); err != nil {
+		return err
+	}
+
+	image.Path = processed.Path()
+	image.SrcSet = processed.SrcSet()
+	image.PlaceholderStyle = processed.PlaceholderStyle()
+	if image.Sizes == "" {
+		image.Sizes = defaultImageSizes
+	}
+	return nil
+}
+
+func (image *Image) setDimensions(fileWidth int, fileHeight int) error {
+	switch {
+	case image.Width == 0 && image.Height == 0:
+		image.Width, image.Height = fileWidth, fileHeight
+	case image.Height == 0:
+		image.Height = scaleDimension(fileHeight, image.Width, fileWidth)
+	case image.Width == 0:
+		image.Width = scaleDimension(fileWidth, image.Height, fileHeight)
+	default:
+		// Allows for rounding to whole pixels
+		expectedHeight := scaleDimension(fileHeight, image.Width, fileWidth)
+		if image.Height < expectedHeight-1 || image.Height > expectedHeight+1 {
+			return fmt.Errorf(
+				"width and height %dx%d in frontmatter do not match aspect ratio of image file "+
+					"'%s' (%dx%d), expected height %d for width %d",
+				image.Width,
+				image.Height,
+				image.Path,
+				fileWidth,
+				fileHeight,
+				expectedHeight,
+				image.Width,
+			)
+		}
+	}
+	return nil
+}
+
+type ParsedIndexPage struct {
+	Content     IndexPageMarkdown
+	AboutMeText template.HTML
+	ParseTime   time.Duration
+}
+
+var (
+	parsedIndexPage     = NewBuildOutput[ParsedIndexPage]("parsed index page")
+	parsedProjectGroups = NewBuildOutput[ParsedProjectGroups]("parsed project groups")
+)
+
+// IndexPageStages returns the build stages for the index page. Parsing is split from rendering,
+// since project pages need the parsed project groups (see [ProjectTemplate.IndexPageLink]), while
+// the index page needs all parsed projects before it can be rendered.
+func (renderer *PageRenderer) IndexPageStages(
+	contentPath string,
+	projects []BuildOutput[ParsedProject],
+) (stages []BuildStage, page BuildOutput[Page]) {
+	page = newPageOutput(contentPath)
+	contentFile := fmt.Sprintf("%s/%s", BaseContentDir, contentPath)
+
+	parseStage := BuildStage{
+		Name:        "parse index page",
+		ContentFile: contentFile,
+		Inputs:      nil,
+		Outputs:     []BuildOutputKey{parsedIndexPage, parsedProjectGroups, page},
+		Run: func(ctx context.Context, build BuildState) error {
+			indexPage, projectGroups, err := renderer.ParseIndexPage(ctx, contentPath)
+			if err != nil {
+				return err
+			}
+
+			parsedIndexPage.Set(build, indexPage)
+			parsedProjectGroups.Set(build, projectGroups)
+			page.Set(build, indexPage.Content.Page)
+			return nil
+		},
 	}
 
-	renderer.parsedProjectGroups = projectGroups.list
-	// Signals to other goroutines that project groups have been parsed
-	close(renderer.projectGroupsParsed)
+	renderStage := BuildStage{
+		Name:        "render index page",
+		ContentFile: contentFile,
+		Inputs: append(
+			[]BuildOutputKey{parsedIndexPage, parsedProjectGroups, renderedIcons},
+			OutputKeys(projects...)...,
+		),
+		Outputs: nil,
+		Run: func(ctx context.Context, build BuildState) error {
+			return renderer.RenderIndexPage(
+				ctx,
+				contentPath,
+				parsedIndexPage.Get(build),
+				parsedProjectGroups.Get(build),
+				GetAll(build, projects),
+				renderedIcons.Get(build),
+			)
+		},
+	}
+
+	return []BuildStage{parseStage, renderStage}, page
+}
 
-	renderer.parsedPages <- content.Page
+func (renderer *PageRenderer) ParseIndexPage(
+	ctx context.Context,
+	contentPath string,
+) (ParsedIndexPage, ParsedProjectGroups, error) {
+	parseStart := time.Now()
+	content, aboutMeText, err := renderer.parseIndexPageContent(ctx, contentPath)
+	if err != nil {
+		return ParsedIndexPage{}, ParsedProjectGroups{}, ctxwrap.Error(
+			ctx,
+			err,
+			"failed to parse index page data",
+		)
+	}
+	content.Page.SetCanonicalURL(renderer.commonData.BaseURL)
 
-	// Waits for icons to finish rendering before using them
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-renderer.iconsRendered:
+	projectGroups, err := parseProjectGroups(content.ProjectGroups, renderer.images)
+	if err != nil {
+		return ParsedIndexPage{}, ParsedProjectGroups{}, ctxwrap.Error(
+			ctx,
+			err,
+			"failed to parse project groups",
+		)
 	}
 
-	if err := validateIconsExpectedByIndexPage(renderer.icons); err != nil {
+	return ParsedIndexPage{
+		Content:     content,
+		AboutMeText: aboutMeText,
+		ParseTime:   time.Since(parseStart),
+	}, projectGroups, nil
+}
+
+func (renderer *PageRenderer) RenderIndexPage(
+	ctx context.Context,
+	contentPath string,
+	indexPage ParsedIndexPage,
+	projectGroups ParsedProjectGroups,
+	projects []ParsedProject,
+	icons IconMap,
+) (err error) {
+	if err := validateIconsExpectedByIndexPage(icons); err != nil {
 		return ctxwrap.Error(ctx, err, "icon map was missing icons required by index page")
 	}
 
-	personalInfo, err := content.PersonalInfo.toTemplateFields(renderer.icons)
+	personalInfo, err := indexPage.Content.PersonalInfo.toTemplateFields(icons)
 	if err != nil {
 		return ctxwrap.Error(ctx, err, "failed to parse personal info from index page content")
 	}
 
-ProjectLoop:
-	for range renderer.projectCount {
-		select {
-		case project := <-renderer.parsedProjects:
-			if err = projectGroups.AddIfIncluded(project); err != nil {
-				return ctxwrap.Errorf(
-					ctx,
-					err,
-					"failed to add project '%s' to groups",
-					project.Name,
-				)
-			}
-			if projectGroups.IsFull() {
-				break ProjectLoop
-			}
-		case <-ctx.Done():
-			return nil
+	// The index page must be re-rendered whenever one of the projects it includes changes
+	var includedProjectHashes []string
+	for _, project := range projects {
+		included, err := projectGroups.AddIfIncluded(project)
+		if err != nil {
+			return ctxwrap.Errorf(
+				ctx,
+				err,
+				"failed to add project '%s' to groups",
+				project.Name,
+			)
+		}
+		if included {
+			includedProjectHashes = append(includedProjectHashes, project.Source.InputHash)
 		}
 	}
 	if !projectGroups.IsFull() {
@@ -126,30 +259,63 @@ ProjectLoop:
 	}
 
 	pageTemplate := IndexPageTemplate{
-		IndexPageBase: content.IndexPageBase,
+		IndexPageBase: indexPage.Content.IndexPageBase,
 		Meta: TemplateMetadata{
 			Common: renderer.commonData,
-			Page:   content.Page,
+			Page:   indexPage.Content.Page,
 		},
-		AboutMe:       aboutMeText,
+		AboutMe:       indexPage.AboutMeText,
 		PersonalInfo:  personalInfo,
 		ProjectGroups: projectGroups.ToSlice(),
-		Icons:         renderer.icons,
+		Icons:         icons,
+	}
+	// Personal info includes the age from the birthday in the content, which changes without the
+	// content changing, so we must include it in the hash to re-render the page on birthdays
+	extraInputs := includedProjectHashes
+	for _, field := range personalInfo {
+		extraInputs = append(extraInputs, field.LinkText)
+	}
+
+	contentFile := fmt.Sprintf("%s/%s", BaseContentDir, contentPath)
+	inputHash, err := renderer.cache.hashPageInputs(
+		indexPage.Content.Page.TemplateName,
+		[]string{contentFile},
+		extraInputs...,
+	)
+	if err != nil {
+		return ctxwrap.Error(ctx, err, "failed to hash index page inputs")
 	}
-	if err = renderer.renderPage(ctx, pageTemplate.Meta.Page, pageTemplate); err != nil {
+
+	if err = renderer.renderPage(
+		ctx,
+		pageTemplate.Meta.Page,
+		pageTemplate,
+		PageSource{
+			ContentFile: contentFile,
+			InputHash:   inputHash,
+			ParseTime:   indexPage.ParseTime,
+		},
+	); err != nil {
 		return ctxwrap.Error(ctx, err, "failed to render index page")
 	}
 
 	return nil
 }
 
-func parseIndexPageContent(
+func (renderer *PageRenderer) parseIndexPageContent(
 	ctx context.Context,
 	contentPath string,
 ) (content IndexPageMarkdown, aboutMeText template.HTML, err error) {
 	path := fmt.Sprintf("%s/%s", BaseContentDir, contentPath)
 	aboutMeBuffer := new(bytes.Buffer)
-	if err := readMarkdownWithFrontmatter(ctx, path, aboutMeBuffer, &content); err != nil {
+	frontmatter, err := renderer.readMarkdownWithFrontmatter(
+		ctx,
+		path,
+		aboutMeBuffer,
+		&content,
+		nil,
+	)
+	if err != nil {
 		return IndexPageMarkdown{}, "", ctxwrap.Error(
 			ctx,
 			err,
@@ -157,10 +323,16 @@ func parseIndexPageContent(
 		)
 	}
 
-	if err := validate.Struct(content); err != nil {
+	if err := validateYAML(content, frontmatter); err != nil {
 		return IndexPageMarkdown{}, "", ctxwrap.Error(ctx, err, "invalid index page metadata")
 	}
 
+	for _, image := range []*Image{&content.ProfilePictureMobile, &content.ProfilePictureDesktop} {
+		if err := image.process(renderer.images); err != nil {
+			return IndexPageMarkdown{}, "", ctxwrap.Error(ctx, err, "invalid index page image")
+		}
+	}
+
 	aboutMeText = removeParagraphTagsAroundHTML(aboutMeBuffer.String())
 
 	return content, aboutMeText, nil
@@ -224,9 +396,13 @@ func ageFromBirthday(birthday time.Time) int {
 	return age
 }
 
-func parseProjectGroups(groups []ProjectGroupMarkdown) (ParsedProjectGroups, error) {
+func parseProjectGroups(
+	groups []ProjectGroupMarkdown,
+	images *ImagePipeline,
+) (ParsedProjectGroups, error) {
 	parsedGroups := make([]ParsedProjectGroup, len(groups))
 	targetNumberOfProjects := 0
+	markdown := newMarkdownParser(images, nil)
 
 	for i, group := range groups {
 		projectsLength := len(group.ProjectPaths)
@@ -240,7 +416,7 @@ func parseProjectGroups(groups []ProjectGroupMarkdown) (ParsedProjectGroups, err
 		var introText template.HTML
 		if group.IntroText != "" {
 			var builder strings.Builder
-			if err := newMarkdownParser().Convert([]byte(group.IntroText), &builder); err != nil {
+			if err := markdown.Convert([]byte(group.IntroText), &builder); err != nil {
 				return ParsedProjectGroups{}, wrap.Errorf(
 					err,
 					"failed to parse intro text for project '%s' as Markdown",
@@ -281,7 +457,7 @@ type ParsedProjectGroup struct {
 	contentDir            string
 }
 
-func (groups *ParsedProjectGroups) AddIfIncluded(project ParsedProject) error {
+func (groups *ParsedProjectGroups) AddIfIncluded(project ParsedProject) (included bool, err error) {
 	var group ParsedProjectGroup
 	isIncluded := false
 	for _, candidate := range groups.list {
@@ -292,26 +468,26 @@ func (groups *ParsedProjectGroups) AddIfIncluded(project ParsedProject) error {
 		}
 	}
 	if !isIncluded {
-		return nil
+		return false, nil
 	}
 
 	index, isIncluded := group.projectIndiciesByPath[project.Page.Path]
 	if !isIncluded {
-		return nil
+		return false, nil
 	}
 
 	projects := group.Projects
 	if index >= len(projects) {
-		return fmt.Errorf("project index in group '%s' is out-of-bounds", group.Title)
+		return false, fmt.Errorf("project index in group '%s' is out-of-bounds", group.Title)
 	}
 
 	if project.Logo.Path == "" && project.IndexPageFallbackIcon == "" {
-		return fmt.Errorf("no icon found for project '%s'", project.Name)
+		return false, fmt.Errorf("no icon found for project '%s'", project.Name)
 	}
 
 	group.Projects[index] = project.ProjectProfile
 	groups.numberOfProjects++
-	return nil
+	return true, nil
 }
 
 func (groups *ParsedProjectGroups) IsFull() bool {
@@ -4,13 +4,8 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"image"
-	_ "image/png"
-	"os"
 	"strconv"
 
-	"hermannm.dev/wrap"
-
 	"github.com/yuin/goldmark/ast"
 	render "github.com/yuin/goldmark/renderer"
 	"github.com/yuin/goldmark/renderer/html"
@@ -20,16 +15,28 @@ import (
 // MarkdownRenderer is a markdown renderer which:
 //   - adds class="break-words" to all links, and target="_blank" to all external links
 //   - adds stand-alone images as <figure>, with alt text in a <figcaption>
+//   - resolves relative image paths against the [PageBundle] of the page
+//   - generates resized variants of images with the [ImagePipeline], listed in srcset
+//   - lazy-loads images, showing a placeholder from the [ImagePipeline] until they have loaded
 //
 // Rendering implementations are based on the originals from Goldmark:
 // https://github.com/yuin/goldmark/blob/b2df67847ed38c31cf4f9e32483377a8e907a6ae/renderer/html/html.go
 type MarkdownRenderer struct {
 	html.Config
+	images *ImagePipeline
+	// Nil if the page is not a page bundle.
+	bundle *PageBundle
 }
 
-func NewMarkdownRenderer(opts ...html.Option) render.NodeRenderer {
+func NewMarkdownRenderer(
+	images *ImagePipeline,
+	bundle *PageBundle,
+	opts ...html.Option,
+) render.NodeRenderer {
 	linkRenderer := &MarkdownRenderer{
 		Config: html.NewConfig(),
+		images: images,
+		bundle: bundle,
 	}
 
 	for _, opt := range opts {
@@ -136,16 +143,16 @@ func (renderer MarkdownRenderer) RenderImage(
 		return ast.WalkContinue, nil
 	}
 
-	destination := util.EscapeHTML(util.URLEscape(img.Destination, true))
+	imagePath, err := renderer.bundle.Resolve(string(img.Destination))
+	if err != nil {
+		return ast.WalkStop, err
+	}
 
-	width, height, err := getImageDimensions(BaseOutputDir + string(destination))
+	processed, err := renderer.images.Process(imagePath)
 	if err != nil {
-		return ast.WalkStop, wrap.Errorf(
-			err,
-			"failed to get dimensions for img '%s'",
-			string(destination),
-		)
+		return ast.WalkStop, err
 	}
+	destination := util.EscapeHTML(util.URLEscape([]byte(processed.Path()), true))
 
 	_, _ = writer.WriteString(`<a href="`)
 	_, _ = writer.Write(destination)
@@ -154,10 +161,23 @@ func (renderer MarkdownRenderer) RenderImage(
 	_, _ = writer.WriteString(`<img src="`)
 	_, _ = writer.Write(destination)
 
+	_, _ = writer.WriteString(`" srcset="`)
+	_, _ = writer.Write(util.EscapeHTML([]byte(processed.SrcSet())))
+	_, _ = writer.WriteString(`" sizes="`)
+	_, _ = writer.Write(util.EscapeHTML([]byte(renderer.images.config.MarkdownSizes)))
+
 	_, _ = writer.WriteString(`" width="`)
-	_, _ = writer.WriteString(strconv.Itoa(width))
+	_, _ = writer.WriteString(strconv.Itoa(processed.Width))
 	_, _ = writer.WriteString(`" height="`)
-	_, _ = writer.WriteString(strconv.Itoa(height))
+	_, _ = writer.WriteString(strconv.Itoa(processed.Height))
+
+	_, _ = writer.WriteString(`" loading="lazy" decoding="async`)
+	if placeholderStyle := processed.PlaceholderStyle(); placeholderStyle != "" {
+		_, _ = writer.WriteString(`" style="`)
+		_, _ = writer.Write(util.EscapeHTML([]byte(placeholderStyle)))
+		_, _ = writer.WriteString(`" onload="`)
+		_, _ = writer.WriteString(removePlaceholderScript)
+	}
 
 	// Set empty alt attribute, since we set figcaption below
 	// Rationale: https://stackoverflow.com/a/58468470
@@ -187,7 +207,7 @@ func (renderer MarkdownRenderer) RenderImage(
 
 	_, _ = writer.WriteString(`<figcaption class="italic text-center mb-1">`)
 	_, _ = writer.Write(altText)
-	_, _ = writer.WriteString("</p>")
+	_, _ = writer.WriteString("</figcaption>")
 
 	return ast.WalkSkipChildren, nil
 }
@@ -205,17 +225,3 @@ func nodeToHTMLText(n ast.Node, source []byte) []byte {
 	}
 	return buf.Bytes()
 }
-
-func getImageDimensions(path string) (width int, height int, err error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return 0, 0, wrap.Error(err, "failed to open image file")
-	}
-
-	config, _, err := image.DecodeConfig(file)
-	if err != nil {
-		return 0, 0, wrap.Error(err, "failed to decode config from image")
-	}
-
-	return config.Width, config.Height, nil
-}
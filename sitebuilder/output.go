@@ -0,0 +1,141 @@
+package sitebuilder
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing/fstest"
+	"time"
+
+	"hermannm.dev/wrap"
+)
+
+// OutputFS is where [PageRenderer] writes the built site. Paths are slash-separated and relative
+// to the root of the output (like paths for [fs.FS]), so "/casus-belli" is written to
+// "casus-belli.html".
+//
+// The output is also readable as an [fs.FS], so that we can check for existing outputs (see
+// [BuildCache]) and serve the built site.
+type OutputFS interface {
+	fs.FS
+	MkdirAll(dir string) error
+	WriteFile(path string, content []byte) error
+	// Removes a file or an empty directory.
+	Remove(path string) error
+}
+
+// DiskOutput writes output files under a directory on disk.
+type DiskOutput struct {
+	Dir string
+}
+
+func NewDiskOutput(dir string) DiskOutput {
+	return DiskOutput{Dir: dir}
+}
+
+func (output DiskOutput) Open(name string) (fs.File, error) {
+	return os.DirFS(output.Dir).Open(name)
+}
+
+func (output DiskOutput) MkdirAll(dir string) error {
+	if err := os.MkdirAll(output.DiskPath(dir), 0o755); err != nil {
+		return wrap.Errorf(err, "failed to create output directory '%s'", dir)
+	}
+	return nil
+}
+
+func (output DiskOutput) WriteFile(path string, content []byte) error {
+	if err := os.WriteFile(output.DiskPath(path), content, 0o644); err != nil {
+		return wrap.Errorf(err, "failed to write output file '%s'", path)
+	}
+	return nil
+}
+
+func (output DiskOutput) Remove(path string) error {
+	if err := os.Remove(output.DiskPath(path)); err != nil {
+		return wrap.Errorf(err, "failed to remove output file '%s'", path)
+	}
+	return nil
+}
+
+// DiskPath converts a path relative to the output root to a path on disk, for passing to external
+// commands.
+func (output DiskOutput) DiskPath(path string) string {
+	return filepath.Join(output.Dir, filepath.FromSlash(path))
+}
+
+// MemoryOutput keeps output files in memory, for building the site in tests or previews without
+// touching the working tree. It is safe for concurrent use.
+type MemoryOutput struct {
+	files fstest.MapFS
+	lock  sync.RWMutex
+}
+
+func NewMemoryOutput() *MemoryOutput {
+	return &MemoryOutput{files: make(fstest.MapFS), lock: sync.RWMutex{}}
+}
+
+func (output *MemoryOutput) Open(name string) (fs.File, error) {
+	output.lock.RLock()
+	defer output.lock.RUnlock()
+
+	return output.files.Open(name)
+}
+
+func (output *MemoryOutput) MkdirAll(dir string) error {
+	if dir == "" || dir == "." {
+		return nil
+	}
+	if !fs.ValidPath(dir) {
+		return fmt.Errorf("invalid output directory path '%s'", dir)
+	}
+
+	output.lock.Lock()
+	defer output.lock.Unlock()
+
+	if _, exists := output.files[dir]; !exists {
+		//nolint:exhaustruct
+		output.files[dir] = &fstest.MapFile{Mode: fs.ModeDir | 0o755, ModTime: time.Now()}
+	}
+	return nil
+}
+
+func (output *MemoryOutput) WriteFile(path string, content []byte) error {
+	if !fs.ValidPath(path) {
+		return fmt.Errorf("invalid output file path '%s'", path)
+	}
+
+	output.lock.Lock()
+	defer output.lock.Unlock()
+
+	// Existing files are replaced rather than modified, so files already opened by readers are
+	// unaffected
+	//nolint:exhaustruct
+	output.files[path] = &fstest.MapFile{
+		Data:    slices.Clone(content),
+		Mode:    0o644,
+		ModTime: time.Now(),
+	}
+	return nil
+}
+
+func (output *MemoryOutput) Remove(path string) error {
+	output.lock.Lock()
+	defer output.lock.Unlock()
+
+	if _, exists := output.files[path]; !exists {
+		return fmt.Errorf("failed to remove output file '%s': %w", path, fs.ErrNotExist)
+	}
+	for otherPath := range output.files {
+		if strings.HasPrefix(otherPath, path+"/") {
+			return fmt.Errorf("failed to remove output directory '%s': directory not empty", path)
+		}
+	}
+
+	delete(output.files, path)
+	return nil
+}
@@ -0,0 +1,85 @@
+package sitebuilder
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Name of the markdown file in a page bundle directory.
+const PageBundleIndexFile = "index.md"
+
+// PageBundle is a directory with the markdown file of a page (see [PageBundleIndexFile]), along
+// with the files that the page uses, such as screenshots. This keeps images next to the content
+// that uses them, instead of in [BaseAssetsDir].
+//
+// Relative paths to files in the page's markdown and frontmatter resolve against the bundle
+// directory (see [PageBundle.Resolve]), and the files are copied to the output next to the page.
+type PageBundle struct {
+	// Path of the bundle directory, including BaseContentDir.
+	dir string
+	// Points to the page in the frontmatter that is being parsed, so that we can place files next
+	// to the page once its path has been parsed.
+	page *Page
+	// Source paths of the files in the bundle, other than the markdown file. Populated by
+	// addAssets.
+	files []string
+}
+
+func NewPageBundle(dir string, page *Page) *PageBundle {
+	return &PageBundle{dir: dir, page: page, files: nil}
+}
+
+// Adds the files in the bundle to the asset manifest, with output paths under the page's path.
+// Must be called after parsing the page's frontmatter, and before resolving paths.
+func (bundle *PageBundle) addAssets(assets *AssetManifest) error {
+	if bundle.page.Path == "" {
+		return fmt.Errorf("missing page path for page bundle '%s'", bundle.dir)
+	}
+
+	files, err := assets.addFiles(
+		bundle.dir,
+		bundle.page.Path,
+		func(relativePath string) (string, bool) {
+			return path.Join(bundle.dir, relativePath), relativePath != PageBundleIndexFile
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	bundle.files = files
+	return nil
+}
+
+// Resolve returns the asset path to pass to the [AssetManifest] or [ImagePipeline] for the given
+// file path from content. Absolute paths refer to files in [BaseAssetsDir], and are returned as-is.
+// Relative paths refer to files in the bundle. Nil bundles (for pages that are not bundles) only
+// allow absolute paths.
+func (bundle *PageBundle) Resolve(filePath string) (string, error) {
+	if strings.HasPrefix(filePath, "/") {
+		return filePath, nil
+	}
+	if bundle == nil {
+		return "", fmt.Errorf(
+			"relative path '%s' is only allowed in page bundles (directories with an '%s' file)",
+			filePath,
+			PageBundleIndexFile,
+		)
+	}
+
+	resolved := path.Join(bundle.dir, filePath)
+	if !strings.HasPrefix(resolved, bundle.dir+"/") {
+		return "", fmt.Errorf("path '%s' is outside page bundle '%s'", filePath, bundle.dir)
+	}
+	return resolved, nil
+}
+
+// Files returns the source paths of the files in the bundle, for hashing page inputs. Returns nil
+// for nil bundles.
+func (bundle *PageBundle) Files() []string {
+	if bundle == nil {
+		return nil
+	}
+	return bundle.files
+}
@@ -0,0 +1,414 @@
+package sitebuilder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"hermannm.dev/wrap/ctxwrap"
+)
+
+const (
+	PostPageTemplateName = "post_page.html.tmpl"
+	PostListTemplateName = "post_list.html.tmpl"
+	// Path of the first page of the post listing. Later pages are at /posts/page/2 and so on, and
+	// posts from each year are listed at /posts/<year>.
+	PostsPath = "/posts"
+	// Number of posts on each page of the post listing.
+	PostsPerPage = 10
+)
+
+// Date format for post dates shown on pages.
+const postDisplayDateFormat = "2 January 2006"
+
+type PostMarkdown struct {
+	Page    `yaml:",inline"`
+	Date    string   `yaml:"date"      validate:"required,datetime=2006-01-02"`
+	Summary string   `yaml:"summary"   validate:"required"`
+	Tags    []string `yaml:"tags,flow" validate:"dive,required"` // Optional.
+}
+
+// PostProfile is the data about a post that is shown both on the post's page and in post listings.
+type PostProfile struct {
+	Title   string
+	Path    string
+	Date    time.Time
+	Summary string
+	Tags    []string
+}
+
+// DisplayDate returns the post date formatted for readers, e.g. "18 October 2026".
+func (post PostProfile) DisplayDate() string {
+	return post.Date.Format(postDisplayDateFormat)
+}
+
+// ISODate returns the post date on the format used in datetime attributes, e.g. "2026-10-18".
+func (post PostProfile) ISODate() string {
+	return post.Date.Format(frontmatterDateFormat)
+}
+
+type PostPageTemplate struct {
+	Meta    TemplateMetadata
+	Post    PostProfile
+	Content template.HTML
+}
+
+type PostListTemplate struct {
+	Meta    TemplateMetadata
+	Heading string
+	// Sorted by date, newest first.
+	Posts []PostProfile
+	// Years that have posts, newest first, for linking to per-year archives.
+	Years []PostYear
+	// Paths to the neighboring pages of a paginated listing. Blank if there is no such page.
+	NewerPostsPath string
+	OlderPostsPath string
+}
+
+type PostYear struct {
+	Year int
+	Path string
+}
+
+type ParsedPost struct {
+	PostProfile
+	Page Page
+	// The post body rendered from Markdown, used in feeds.
+	Content template.HTML
+	// The input hash in the source is also used by the post listings, which must be re-rendered
+	// when one of their posts changes.
+	Source PageSource
+}
+
+// PostStages returns build stages for rendering a page for each of the given post files, and the
+// post listings. The parsed posts are produced as separate outputs, so that the listings and feeds
+// can use them. Listings are produced as a single output, since the number of listing pages
+// depends on the parsed posts.
+func (renderer *PageRenderer) PostStages(postFiles []ContentFile) (
+	stages []BuildStage,
+	posts []BuildOutput[ParsedPost],
+	pages []BuildOutput[Page],
+	listPages BuildOutput[[]Page],
+) {
+	posts = make([]BuildOutput[ParsedPost], 0, len(postFiles))
+	for _, postFile := range postFiles {
+		post := NewBuildOutput[ParsedPost](fmt.Sprintf("post from '%s'", postFile.path()))
+		page := newPageOutput(postFile.path())
+
+		stages = append(
+			stages,
+			BuildStage{
+				Name:        fmt.Sprintf("render post page '%s'", postFile.path()),
+				ContentFile: postFile.contentFilePath(),
+				// Posts use no icons directly, but the footer uses the GitHub icon from commonData
+				Inputs:  []BuildOutputKey{renderedIcons},
+				Outputs: []BuildOutputKey{post, page},
+				Run: func(ctx context.Context, build BuildState) error {
+					parsedPost, err := renderer.RenderPost(ctx, postFile)
+					if err != nil {
+						return ctxwrap.Errorf(ctx, err, "failed to render post '%s'", postFile.name)
+					}
+
+					post.Set(build, parsedPost)
+					page.Set(build, parsedPost.Page)
+					return nil
+				},
+			},
+		)
+		posts = append(posts, post)
+		pages = append(pages, page)
+	}
+
+	listPages = NewBuildOutput[[]Page]("post listing pages")
+	stages = append(
+		stages,
+		BuildStage{
+			Name:        "render post listings",
+			ContentFile: "",
+			Inputs:      append(OutputKeys(posts...), renderedIcons),
+			Outputs:     []BuildOutputKey{listPages},
+			Run: func(ctx context.Context, build BuildState) error {
+				renderedPages, err := renderer.RenderPostListings(ctx, GetAll(build, posts))
+				if err != nil {
+					return err
+				}
+				listPages.Set(build, renderedPages)
+				return nil
+			},
+		},
+	)
+
+	return stages, posts, pages, listPages
+}
+
+// RenderPost parses the given post file and renders its page.
+func (renderer *PageRenderer) RenderPost(
+	ctx context.Context,
+	postFile ContentFile,
+) (ParsedPost, error) {
+	parseStart := time.Now()
+	markdownFilePath := postFile.contentFilePath()
+
+	content := new(bytes.Buffer)
+	var post PostMarkdown
+	var bundle *PageBundle
+	if postFile.isBundle {
+		bundle = NewPageBundle(path.Dir(markdownFilePath), &post.Page)
+	}
+	frontmatter, err := renderer.readMarkdownWithFrontmatter(
+		ctx,
+		markdownFilePath,
+		content,
+		&post,
+		bundle,
+	)
+	if err != nil {
+		return ParsedPost{}, ctxwrap.Error(ctx, err, "failed to read markdown for post")
+	}
+
+	post.Page.TemplateName = PostPageTemplateName
+	post.Page.SetCanonicalURL(renderer.commonData.BaseURL)
+
+	if err := validateYAML(post, frontmatter); err != nil {
+		return ParsedPost{}, ctxwrap.Error(ctx, err, "invalid post metadata")
+	}
+	// Post listings are rendered after the posts, so they would overwrite a post with their path
+	if isPostListingPath(post.Page.Path) {
+		return ParsedPost{}, ctxwrap.NewErrorf(
+			ctx,
+			"post path '%s' is reserved for post listings (%s, %s/page/<number> and %s/<year>)",
+			post.Page.Path,
+			PostsPath,
+			PostsPath,
+			PostsPath,
+		)
+	}
+
+	// Already validated to be on this format
+	date, err := time.Parse(frontmatterDateFormat, post.Date)
+	if err != nil {
+		return ParsedPost{}, ctxwrap.Error(ctx, err, "invalid post date")
+	}
+
+	inputHash, err := renderer.cache.hashPageInputs(
+		post.Page.TemplateName,
+		append([]string{markdownFilePath}, bundle.Files()...),
+	)
+	if err != nil {
+		return ParsedPost{}, ctxwrap.Error(ctx, err, "failed to hash post page inputs")
+	}
+
+	parsedPost := ParsedPost{
+		PostProfile: PostProfile{
+			Title:   post.Page.Title,
+			Path:    post.Page.Path,
+			Date:    date,
+			Summary: post.Summary,
+			Tags:    post.Tags,
+		},
+		Page:    post.Page,
+		Content: template.HTML(content.String()),
+		Source: PageSource{
+			ContentFile: markdownFilePath,
+			InputHash:   inputHash,
+			ParseTime:   time.Since(parseStart),
+		},
+	}
+
+	postPage := PostPageTemplate{
+		Meta: TemplateMetadata{
+			Common: renderer.commonData,
+			Page:   post.Page,
+		},
+		Post:    parsedPost.PostProfile,
+		Content: parsedPost.Content,
+	}
+	if err := renderer.renderPageWithAndWithoutTrailingSlash(
+		ctx,
+		postPage.Meta.Page,
+		postPage,
+		parsedPost.Source,
+	); err != nil {
+		return ParsedPost{}, ctxwrap.Errorf(ctx, err, "failed to render page '%s'", post.Page.Path)
+	}
+
+	return parsedPost, nil
+}
+
+// RenderPostListings renders the paginated post listing at [PostsPath], and an archive page for
+// each year with posts. Returns the rendered pages.
+func (renderer *PageRenderer) RenderPostListings(
+	ctx context.Context,
+	posts []ParsedPost,
+) ([]Page, error) {
+	posts = slices.Clone(posts)
+	slices.SortFunc(
+		posts,
+		func(post1 ParsedPost, post2 ParsedPost) int {
+			if order := post2.Date.Compare(post1.Date); order != 0 {
+				return order
+			}
+			return strings.Compare(post1.Title, post2.Title)
+		},
+	)
+
+	// Every listing links to the per-year archives, so any post change may affect every listing
+	inputHashes := make([]string, 0, len(posts))
+	var years []PostYear
+	for _, post := range posts {
+		inputHashes = append(inputHashes, post.Source.InputHash)
+
+		year := post.Date.Year()
+		if len(years) == 0 || years[len(years)-1].Year != year {
+			years = append(years, PostYear{Year: year, Path: postYearPath(year)})
+		}
+	}
+
+	var pages []Page
+
+	pageCount := max((len(posts)+PostsPerPage-1)/PostsPerPage, 1)
+	for pageIndex := range pageCount {
+		listing := PostListTemplate{
+			Meta:           TemplateMetadata{}, // Set by renderPostListing
+			Heading:        "Posts",
+			Posts:          nil,
+			Years:          years,
+			NewerPostsPath: "",
+			OlderPostsPath: "",
+		}
+		start := pageIndex * PostsPerPage
+		end := min(start+PostsPerPage, len(posts))
+		for _, post := range posts[start:end] {
+			listing.Posts = append(listing.Posts, post.PostProfile)
+		}
+		if pageIndex > 0 {
+			listing.NewerPostsPath = postListPagePath(pageIndex - 1)
+		}
+		if pageIndex < pageCount-1 {
+			listing.OlderPostsPath = postListPagePath(pageIndex + 1)
+		}
+
+		pagePath := postListPagePath(pageIndex)
+		page, err := renderer.renderPostListing(ctx, pagePath, listing, inputHashes)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+
+	for _, year := range years {
+		listing := PostListTemplate{
+			Meta:           TemplateMetadata{}, // Set by renderPostListing
+			Heading:        fmt.Sprintf("Posts from %d", year.Year),
+			Posts:          nil,
+			Years:          years,
+			NewerPostsPath: "",
+			OlderPostsPath: "",
+		}
+		for _, post := range posts {
+			if post.Date.Year() == year.Year {
+				listing.Posts = append(listing.Posts, post.PostProfile)
+			}
+		}
+
+		page, err := renderer.renderPostListing(ctx, year.Path, listing, inputHashes)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+
+	return pages, nil
+}
+
+func (renderer *PageRenderer) renderPostListing(
+	ctx context.Context,
+	pagePath string,
+	listing PostListTemplate,
+	postInputHashes []string,
+) (Page, error) {
+	page := Page{
+		Title:        renderer.commonData.SiteName + pagePath,
+		Path:         pagePath,
+		TemplateName: PostListTemplateName,
+		RedirectPath: "",
+		CanonicalURL: "",
+		GoPackage:    nil,
+	}
+	page.SetCanonicalURL(renderer.commonData.BaseURL)
+	listing.Meta = TemplateMetadata{Common: renderer.commonData, Page: page}
+
+	inputHash, err := renderer.cache.hashPageInputs(
+		page.TemplateName,
+		nil,
+		append([]string{pagePath, listing.Heading}, postInputHashes...)...,
+	)
+	if err != nil {
+		return Page{}, ctxwrap.Error(ctx, err, "failed to hash post listing inputs")
+	}
+
+	source := PageSource{ContentFile: "", InputHash: inputHash, ParseTime: 0}
+	if err := renderer.renderPageWithAndWithoutTrailingSlash(
+		ctx,
+		page,
+		listing,
+		source,
+	); err != nil {
+		return Page{}, ctxwrap.Errorf(ctx, err, "failed to render post listing '%s'", pagePath)
+	}
+
+	return page, nil
+}
+
+// Returns the path of the post listing page at the given index, starting at 0.
+func postListPagePath(pageIndex int) string {
+	if pageIndex == 0 {
+		return PostsPath
+	}
+	return fmt.Sprintf("%s/page/%d", PostsPath, pageIndex+1)
+}
+
+func postYearPath(year int) string {
+	return PostsPath + "/" + strconv.Itoa(year)
+}
+
+// Returns true if the given path is one that [PageRenderer.RenderPostListings] may render, either
+// now or when more posts are added.
+func isPostListingPath(pagePath string) bool {
+	subPath, ok := strings.CutPrefix(strings.TrimSuffix(pagePath, "/"), PostsPath)
+	if !ok {
+		return false
+	}
+	if subPath == "" {
+		return true
+	}
+
+	subPath, ok = strings.CutPrefix(subPath, "/")
+	if !ok {
+		return false // Path that only starts with the same characters, e.g. /postscript
+	}
+	subPath = strings.TrimPrefix(subPath, "page/")
+	return isDigits(subPath)
+}
+
+func isDigits(text string) bool {
+	return text != "" && strings.Trim(text, "0123456789") == ""
+}
+
+// Implements [withPager] to work with [PageRenderer.renderPageWithAndWithoutTrailingSlash].
+func (template PostPageTemplate) withPage(page Page) any {
+	template.Meta.Page = page
+	return template
+}
+
+// Implements [withPager] to work with [PageRenderer.renderPageWithAndWithoutTrailingSlash].
+func (template PostListTemplate) withPage(page Page) any {
+	template.Meta.Page = page
+	return template
+}
@@ -0,0 +1,220 @@
+package sitebuilder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPostListingPagination(t *testing.T) {
+	testCases := []struct {
+		postCount int
+		// Post paths expected on each listing page, newest first
+		expectedPages [][]string
+	}{
+		{
+			postCount:     0,
+			expectedPages: [][]string{nil},
+		},
+		{
+			postCount:     10,
+			expectedPages: [][]string{testPostPaths(10, 1)},
+		},
+		{
+			postCount:     11,
+			expectedPages: [][]string{testPostPaths(11, 2), testPostPaths(1, 1)},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(fmt.Sprintf("%d posts", testCase.postCount), func(t *testing.T) {
+			setUpTestSite(t)
+			removeTestPosts(t)
+			for i := 1; i <= testCase.postCount; i++ {
+				writeTestPost(t, fmt.Sprintf("/posts/post-%02d", i), fmt.Sprintf("2024-01-%02d", i))
+			}
+			output := NewMemoryOutput()
+			renderTestSite(t, output, NewBuildCache(""))
+
+			pageCount := len(testCase.expectedPages)
+			for pageIndex, expectedPosts := range testCase.expectedPages {
+				pagePath := postListPagePath(pageIndex)
+				listing := readOutputFile(t, output, strings.TrimPrefix(pagePath, "/")+".html")
+
+				if posts := listedPostPaths(listing); !slices.Equal(posts, expectedPosts) {
+					t.Errorf(
+						"expected '%s' to list posts:\n%v\ngot:\n%v",
+						pagePath,
+						expectedPosts,
+						posts,
+					)
+				}
+				if len(expectedPosts) == 0 && !strings.Contains(listing, "No posts yet.") {
+					t.Errorf("expected '%s' to say there are no posts, got:\n%s", pagePath, listing)
+				}
+
+				links := []struct {
+					text     string
+					path     string
+					expected bool
+				}{
+					{"Newer posts", postListPagePath(pageIndex - 1), pageIndex > 0},
+					{"Older posts", postListPagePath(pageIndex + 1), pageIndex < pageCount-1},
+				}
+				for _, link := range links {
+					linkHTML := fmt.Sprintf(`href="%s">%s`, link.path, link.text)
+					if hasLink := strings.Contains(listing, linkHTML); hasLink != link.expected {
+						t.Errorf(
+							"expected '%s' to have link '%s': %t, got %t",
+							pagePath,
+							link.text,
+							link.expected,
+							hasLink,
+						)
+					}
+				}
+			}
+
+			lastPagePath := strings.TrimPrefix(postListPagePath(pageCount), "/") + ".html"
+			if _, err := output.Open(lastPagePath); err == nil {
+				t.Errorf("expected no listing page '%s'", lastPagePath)
+			}
+		})
+	}
+}
+
+func TestPostYearArchives(t *testing.T) {
+	setUpTestSite(t)
+	// In addition to first-post from 2024-05-01
+	writeTestPost(t, "/posts/old-post", "2023-03-01")
+	writeTestPost(t, "/posts/newer-old-post", "2023-11-01")
+	writeTestPost(t, "/posts/new-post", "2024-02-01")
+	output := NewMemoryOutput()
+	renderTestSite(t, output, NewBuildCache(""))
+
+	expectedArchives := map[string][]string{
+		"posts/2023.html": {"/posts/newer-old-post", "/posts/old-post"},
+		"posts/2024.html": {"/posts/first-post", "/posts/new-post"},
+	}
+	for archivePath, expectedPosts := range expectedArchives {
+		archive := readOutputFile(t, output, archivePath)
+		if posts := listedPostPaths(archive); !slices.Equal(posts, expectedPosts) {
+			t.Errorf(
+				"expected '%s' to list posts:\n%v\ngot:\n%v",
+				archivePath,
+				expectedPosts,
+				posts,
+			)
+		}
+	}
+	if _, err := output.Open("posts/2022.html"); err == nil {
+		t.Error("expected no archive for year without posts")
+	}
+
+	// Every listing links to the archives, newest year first
+	archiveLinks := regexp.MustCompile(`href="/posts/(\d+)"`).
+		FindAllStringSubmatch(readOutputFile(t, output, "posts.html"), -1)
+	var years []string
+	for _, link := range archiveLinks {
+		years = append(years, link[1])
+	}
+	if expectedYears := []string{"2024", "2023"}; !slices.Equal(years, expectedYears) {
+		t.Errorf("expected archive links for years %v, got %v", expectedYears, years)
+	}
+}
+
+func TestPostWithListingPath(t *testing.T) {
+	setUpTestSite(t)
+	writeTestPost(t, "/posts/page/2", "2024-02-01")
+
+	_, _, err := tryRenderTestSite(t, NewMemoryOutput(), NewBuildCache(""))
+	expectedError := "post path '/posts/page/2' is reserved for post listings"
+	if err == nil || !strings.Contains(err.Error(), expectedError) {
+		t.Errorf("expected error containing %q, got %v", expectedError, err)
+	}
+}
+
+func TestIsPostListingPath(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected bool
+	}{
+		{path: "/posts", expected: true},
+		{path: "/posts/", expected: true},
+		{path: "/posts/page/2", expected: true},
+		{path: "/posts/page/10/", expected: true},
+		{path: "/posts/2024", expected: true},
+		{path: "/posts/first-post", expected: false},
+		{path: "/posts/2024-recap", expected: false},
+		{path: "/posts/2024/recap", expected: false},
+		{path: "/posts/page", expected: false},
+		{path: "/posts/page/two", expected: false},
+		{path: "/postscript", expected: false},
+		{path: "/2024", expected: false},
+	}
+
+	for _, testCase := range testCases {
+		if isListing := isPostListingPath(testCase.path); isListing != testCase.expected {
+			t.Errorf(
+				"expected isPostListingPath(%q) to be %t, got %t",
+				testCase.path,
+				testCase.expected,
+				isListing,
+			)
+		}
+	}
+}
+
+// Writes a post with the given path and date to the posts directory of the test site (see
+// [setUpTestSite]).
+func writeTestPost(t *testing.T, postPath string, date string) {
+	t.Helper()
+
+	content := fmt.Sprintf(
+		"---\ntitle: Post at %s\npath: %s\ndate: \"%s\"\nsummary: A test post.\n---\n\nContent.\n",
+		postPath,
+		postPath,
+		date,
+	)
+	fileName := strings.ReplaceAll(strings.Trim(postPath, "/"), "/", "-") + ".md"
+	if err := os.WriteFile(
+		filepath.Join("content", "posts", fileName),
+		[]byte(content),
+		0o644,
+	); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Removes the fixture posts from the test site, so that tests can write their own.
+func removeTestPosts(t *testing.T) {
+	t.Helper()
+
+	if err := os.Remove(filepath.Join("content", "posts", "first-post.md")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Returns the paths of the posts written by [writeTestPost] for the given range of numbers, from
+// the newest post to the oldest.
+func testPostPaths(newest int, oldest int) []string {
+	var paths []string
+	for i := newest; i >= oldest; i-- {
+		paths = append(paths, fmt.Sprintf("/posts/post-%02d", i))
+	}
+	return paths
+}
+
+// Returns the paths of the posts in the given post listing page, in the order they are listed.
+func listedPostPaths(listing string) []string {
+	var paths []string
+	for _, match := range regexp.MustCompile(`<a class="text-lg font-bold" href="([^"]+)">`).
+		FindAllStringSubmatch(listing, -1) {
+		paths = append(paths, match[1])
+	}
+	return paths
+}
@@ -5,9 +5,9 @@ import (
 	"context"
 	"fmt"
 	"html/template"
-	"io/fs"
-	"os"
+	"path"
 	"strings"
+	"time"
 
 	"hermannm.dev/wrap/ctxwrap"
 )
@@ -20,6 +20,10 @@ type ProjectProfile struct {
 	Logo struct {
 		Path    string `yaml:"path"    validate:"omitempty,filepath"`
 		AltText string `yaml:"altText"`
+		// Populated by the [ImagePipeline] after parsing.
+		Width            int          `yaml:"-"`
+		Height           int          `yaml:"-"`
+		PlaceholderStyle template.CSS `yaml:"-"`
 	} `yaml:"logo"`
 	IndexPageFallbackIcon template.HTML
 }
@@ -28,8 +32,8 @@ type ProjectBase struct {
 	ProjectProfile `yaml:",inline"`
 	// Optional, defaults to DefaultTechStackTitle when TechStack is not empty.
 	TechStackTitle string         `yaml:"techStackTitle"`
-	Links          []TopLevelLink `yaml:"links,flow"` // Optional.
-	Footnote       template.HTML  `yaml:"footnote"`   // Optional.
+	Links          []TopLevelLink `yaml:"links,flow" validate:"dive"` // Optional.
+	Footnote       template.HTML  `yaml:"footnote"`                   // Optional.
 }
 
 type TopLevelLink struct {
@@ -41,6 +45,9 @@ type TopLevelLink struct {
 type ProjectMarkdown struct {
 	ProjectBase `yaml:",inline"`
 	TechStack   []TechStackItemMarkdown `yaml:"techStack,flow"` // Optional.
+	// Optional. Projects with a date (when the project was published or released) are included in
+	// the site's feeds.
+	Date string `yaml:"date" validate:"omitempty,datetime=2006-01-02"`
 }
 
 type ProjectTemplate struct {
@@ -53,8 +60,8 @@ type ProjectTemplate struct {
 	//
 	// We can link directly to a tab using fragments (#) in the URL (see script at bottom of
 	// index_page.html.tmpl), using the slug of the project group ("projects"/"work"/"libraries").
-	// These slugs are defined by the index page, so we wait for the index page goroutine to parse
-	// its project groups, before using them to find the correct group slug for each project.
+	// These slugs are defined by the index page, so project stages depend on the
+	// parsedProjectGroups build output, and use it to find the correct group slug for each project.
 	IndexPageLink string
 }
 
@@ -79,25 +86,50 @@ type ParsedProject struct {
 	ProjectTemplate
 	Page       Page
 	ContentDir string
+	// Zero if the project has no date in its frontmatter.
+	Date time.Time
+	// The input hash in the source is also used by the index page, which must be re-rendered when
+	// one of its projects changes.
+	Source PageSource
 }
 
-type ProjectContentFile struct {
-	name      string
-	directory string
-}
+// ProjectPageStage returns a build stage that parses the given project file and renders its page.
+// The parsed project is produced as a separate output, so that the index page can list it.
+func (renderer *PageRenderer) ProjectPageStage(projectFile ContentFile) (
+	stage BuildStage,
+	project BuildOutput[ParsedProject],
+	page BuildOutput[Page],
+) {
+	project = NewBuildOutput[ParsedProject](fmt.Sprintf("project from '%s'", projectFile.path()))
+	page = newPageOutput(projectFile.path())
+
+	stage = BuildStage{
+		Name:        fmt.Sprintf("render project page '%s'", projectFile.path()),
+		ContentFile: projectFile.contentFilePath(),
+		Inputs:      []BuildOutputKey{parsedProjectGroups, renderedIcons},
+		Outputs:     []BuildOutputKey{project, page},
+		Run: func(ctx context.Context, build BuildState) error {
+			parsedProject, err := renderer.parseProject(
+				ctx,
+				projectFile,
+				parsedProjectGroups.Get(build).list,
+				renderedIcons.Get(build),
+			)
+			if err != nil {
+				return ctxwrap.Errorf(ctx, err, "failed to parse project '%s'", projectFile.name)
+			}
 
-func (renderer *PageRenderer) RenderProjectPage(
-	ctx context.Context,
-	projectFile ProjectContentFile,
-) error {
-	project, err := renderer.parseProject(ctx, projectFile)
-	if err != nil {
-		return ctxwrap.Errorf(ctx, err, "failed to parse project '%s'", projectFile.name)
+			project.Set(build, parsedProject)
+			page.Set(build, parsedProject.Page)
+
+			return renderer.RenderProjectPage(ctx, parsedProject)
+		},
 	}
 
-	renderer.parsedPages <- project.Page
-	renderer.parsedProjects <- project
+	return stage, project, page
+}
 
+func (renderer *PageRenderer) RenderProjectPage(ctx context.Context, project ParsedProject) error {
 	projectPage := ProjectPageTemplate{
 		Meta: TemplateMetadata{
 			Common: renderer.commonData,
@@ -110,6 +142,7 @@ func (renderer *PageRenderer) RenderProjectPage(
 		ctx,
 		projectPage.Meta.Page,
 		projectPage,
+		project.Source,
 	); err != nil {
 		return ctxwrap.Errorf(ctx, err, "failed to render page for project '%s'", project.Name)
 	}
@@ -117,35 +150,6 @@ func (renderer *PageRenderer) RenderProjectPage(
 	return nil
 }
 
-func readProjectContentDirs(ctx context.Context, contentDirNames []string) (
-	[]ProjectContentFile,
-	error,
-) {
-	var files []ProjectContentFile
-	baseContentDir := os.DirFS(BaseContentDir)
-
-	for _, dirName := range contentDirNames {
-		entries, err := fs.ReadDir(baseContentDir, dirName)
-		if err != nil {
-			return nil, ctxwrap.Errorf(
-				ctx,
-				err,
-				"failed to read project content directory '%s'",
-				dirName,
-			)
-		}
-
-		for _, dirEntry := range entries {
-			if !dirEntry.IsDir() {
-				file := ProjectContentFile{name: dirEntry.Name(), directory: dirName}
-				files = append(files, file)
-			}
-		}
-	}
-
-	return files, nil
-}
-
 const (
 	ProjectPageTemplateName = "project_page.html.tmpl"
 	DefaultTechStackTitle   = "Built with"
@@ -153,20 +157,27 @@ const (
 
 func (renderer *PageRenderer) parseProject(
 	ctx context.Context,
-	projectFile ProjectContentFile,
+	projectFile ContentFile,
+	projectGroups []ParsedProjectGroup,
+	icons IconMap,
 ) (ParsedProject, error) {
-	markdownFilePath := fmt.Sprintf(
-		"%s/%s/%s", BaseContentDir, projectFile.directory, projectFile.name,
-	)
+	parseStart := time.Now()
+	markdownFilePath := projectFile.contentFilePath()
 
 	descriptionBuffer := new(bytes.Buffer)
 	var project ProjectMarkdown
-	if err := readMarkdownWithFrontmatter(
+	var bundle *PageBundle
+	if projectFile.isBundle {
+		bundle = NewPageBundle(path.Dir(markdownFilePath), &project.Page)
+	}
+	frontmatter, err := renderer.readMarkdownWithFrontmatter(
 		ctx,
 		markdownFilePath,
 		descriptionBuffer,
 		&project,
-	); err != nil {
+		bundle,
+	)
+	if err != nil {
 		return ParsedProject{}, ctxwrap.Error(ctx, err, "failed to read markdown for project")
 	}
 
@@ -177,13 +188,31 @@ func (renderer *PageRenderer) parseProject(
 		project.TechStackTitle = DefaultTechStackTitle
 	}
 
-	if err := validate.Struct(project); err != nil {
+	if err := validateYAML(project, frontmatter); err != nil {
 		return ParsedProject{}, ctxwrap.Error(ctx, err, "invalid project metadata")
 	}
 
+	if project.Logo.Path != "" {
+		logoPath, err := bundle.Resolve(project.Logo.Path)
+		if err != nil {
+			return ParsedProject{}, ctxwrap.Error(ctx, err, "invalid project logo")
+		}
+		logo, err := renderer.images.Process(logoPath)
+		if err != nil {
+			return ParsedProject{}, ctxwrap.Error(ctx, err, "invalid project logo")
+		}
+		project.Logo.Path = logo.Path()
+		project.Logo.Width = logo.Width
+		project.Logo.Height = logo.Height
+		project.Logo.PlaceholderStyle = logo.PlaceholderStyle()
+	}
+
 	if project.Footnote != "" {
 		var builder strings.Builder
-		if err := newMarkdownParser().Convert([]byte(project.Footnote), &builder); err != nil {
+		if err := newMarkdownParser(renderer.images, bundle).Convert(
+			[]byte(project.Footnote),
+			&builder,
+		); err != nil {
 			return ParsedProject{}, ctxwrap.Errorf(
 				ctx,
 				err,
@@ -194,14 +223,7 @@ func (renderer *PageRenderer) parseProject(
 		project.Footnote = removeParagraphTagsAroundHTML(builder.String())
 	}
 
-	// Waits for index page goroutine to finish parsing project groups
-	select {
-	case <-ctx.Done():
-		return ParsedProject{}, ctx.Err()
-	case <-renderer.projectGroupsParsed:
-	}
-
-	indexPageLink, err := getIndexPageLink(renderer.parsedProjectGroups, projectFile.directory)
+	indexPageLink, err := getIndexPageLink(projectGroups, projectFile.directory)
 	if err != nil {
 		return ParsedProject{}, ctxwrap.Errorf(
 			ctx,
@@ -211,18 +233,11 @@ func (renderer *PageRenderer) parseProject(
 		)
 	}
 
-	// Waits for icons to finish rendering before using them
-	select {
-	case <-ctx.Done():
-		return ParsedProject{}, ctx.Err()
-	case <-renderer.iconsRendered:
-	}
-
-	if err := populateLinkTextAndIcons(project.Links, renderer.icons); err != nil {
+	if err := populateLinkTextAndIcons(project.Links, icons); err != nil {
 		return ParsedProject{}, ctxwrap.Error(ctx, err, "failed to set link icons")
 	}
 
-	techStack, indexPageFallbackIcon, err := parseTechStack(project.TechStack, renderer.icons)
+	techStack, indexPageFallbackIcon, err := parseTechStack(project.TechStack, icons)
 	if err != nil {
 		return ParsedProject{}, ctxwrap.Errorf(
 			ctx,
@@ -234,6 +249,24 @@ func (renderer *PageRenderer) parseProject(
 
 	project.IndexPageFallbackIcon = indexPageFallbackIcon
 
+	var date time.Time
+	if project.Date != "" {
+		// Already validated to be on this format
+		date, err = time.Parse(frontmatterDateFormat, project.Date)
+		if err != nil {
+			return ParsedProject{}, ctxwrap.Error(ctx, err, "invalid project date")
+		}
+	}
+
+	inputHash, err := renderer.cache.hashPageInputs(
+		project.Page.TemplateName,
+		append([]string{markdownFilePath}, bundle.Files()...),
+		indexPageLink,
+	)
+	if err != nil {
+		return ParsedProject{}, ctxwrap.Error(ctx, err, "failed to hash project page inputs")
+	}
+
 	return ParsedProject{
 		ProjectTemplate: ProjectTemplate{
 			ProjectBase:   project.ProjectBase,
@@ -243,6 +276,12 @@ func (renderer *PageRenderer) parseProject(
 		},
 		Page:       project.Page,
 		ContentDir: projectFile.directory,
+		Date:       date,
+		Source: PageSource{
+			ContentFile: markdownFilePath,
+			InputHash:   inputHash,
+			ParseTime:   time.Since(parseStart),
+		},
 	}, nil
 }
 
@@ -323,12 +362,19 @@ func getTechIcon(
 	if techIcon.RenderedIcon == "" {
 		return LinkItem{}, "", fmt.Errorf("tech icon '%s' was not rendered", techName)
 	}
+	techPath, err := techPagePath(techName)
+	if err != nil {
+		return LinkItem{}, "", err
+	}
 
+	// Links to the tech page listing all projects that use the technology, which in turn links to
+	// the technology's website (see [TechPageTemplate])
 	//nolint:exhaustruct
 	return LinkItem{
-		LinkText: techName,
-		Link:     techIcon.Link,
-		Icon:     techIcon.RenderedIcon,
+		LinkText:   techName,
+		Link:       techPath,
+		Icon:       techIcon.RenderedIcon,
+		IsInternal: true,
 	}, techIcon.RenderedIndexPageFallbackIcon, nil
 }
 
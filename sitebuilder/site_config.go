@@ -0,0 +1,61 @@
+package sitebuilder
+
+import (
+	"context"
+	"os"
+
+	"hermannm.dev/wrap/ctxwrap"
+)
+
+const DefaultSiteConfigPath = "site.yaml"
+
+// SiteConfig is loaded from a YAML file (see [LoadSiteConfig]), so that sites can be configured
+// without changing Go code.
+type SiteConfig struct {
+	CommonPageData `yaml:",inline"`
+	ContentPaths   ContentPaths `yaml:"contentPaths"`
+	// Tailwind CSS input file, from which the CSS for the rendered pages is generated.
+	InputCSSFile string  `yaml:"inputCSSFile" validate:"required,filepath"`
+	Icons        IconMap `yaml:"icons"        validate:"required,dive,required"`
+	// Settings for resized image variants, see [ImagePipeline].
+	Images ImageConfig `yaml:"images"`
+	// Whether unknown keys in content frontmatter fail the build ("error", the default) or log a
+	// warning ("warn"). Unknown keys in the site config itself always fail.
+	UnknownFrontmatterKeys UnknownKeyMode `yaml:"unknownFrontmatterKeys" validate:"omitempty,oneof=error warn"`
+	// How pages are formatted when building the site (see [FormatHTML]). Defaults to "pretty". The
+	// dev server does not format pages, to keep rebuilds fast.
+	HTMLFormat HTMLFormat `yaml:"htmlFormat" validate:"omitempty,oneof=pretty minify none"`
+}
+
+// LoadSiteConfig reads and validates the site config at the given path. Unknown keys are rejected,
+// so that typos in the config are not silently ignored.
+func LoadSiteConfig(ctx context.Context, path string) (SiteConfig, error) {
+	configBytes, err := os.ReadFile(path)
+	if err != nil {
+		return SiteConfig{}, ctxwrap.Errorf(ctx, err, "failed to read site config file '%s'", path)
+	}
+
+	var config SiteConfig
+	source, unknownKeys, err := decodeYAML(path, configBytes, 1, &config)
+	if err != nil {
+		return SiteConfig{}, ctxwrap.Errorf(ctx, err, "failed to parse site config file '%s'", path)
+	}
+	if unknownKeys != nil {
+		return SiteConfig{}, ctxwrap.Errorf(
+			ctx,
+			unknownKeys,
+			"unknown keys in site config file '%s'",
+			path,
+		)
+	}
+
+	if err := validateYAML(config, source); err != nil {
+		return SiteConfig{}, ctxwrap.Errorf(ctx, err, "invalid site config in '%s'", path)
+	}
+
+	if config.HTMLFormat == "" {
+		config.HTMLFormat = HTMLFormatPretty
+	}
+
+	return config, nil
+}
@@ -2,23 +2,26 @@ package sitebuilder
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
-	"io/fs"
 	"os"
 	"os/exec"
+	"path"
 	"slices"
 	"strings"
+	"time"
 
-	"hermannm.dev/errclose"
+	"hermannm.dev/devlog/log"
 	"hermannm.dev/wrap"
 	"hermannm.dev/wrap/ctxwrap"
 
 	"github.com/adrg/frontmatter"
-	"github.com/go-playground/validator/v10"
 	"github.com/yuin/goldmark"
 	markdownrenderer "github.com/yuin/goldmark/renderer"
 	"github.com/yuin/goldmark/renderer/html"
@@ -28,145 +31,231 @@ import (
 
 const (
 	BaseContentDir = "content"
-	BaseOutputDir  = "static"
+	// Checked-in static files (fonts, images, favicon), which are copied to the output directory.
+	BaseAssetsDir = "static"
+	// Only contains generated files. Files that were not produced by the latest build are pruned
+	// (see [PruneStaleOutputs]).
+	BaseOutputDir = "dist"
+	// Output directory for [DevPipeline], see [BuildPipeline.OutputDir].
+	DevOutputDir = "dist-dev"
 
 	PageTemplatesDir      = "templates/pages"
 	ComponentTemplatesDir = "templates/components"
 )
 
-var validate = validator.New()
-
+// Paths are relative to [BaseContentDir].
 type ContentPaths struct {
-	IndexPage   string
-	ProjectDirs []string
-	BasicPages  []string
+	IndexPage   string   `yaml:"indexPage"   validate:"required"`
+	ProjectDirs []string `yaml:"projectDirs" validate:"dive,required"`
+	BasicPages  []string `yaml:"basicPages"  validate:"dive,required"`
+	// Directory with posts (see [PostMarkdown]). Optional: if blank, no posts are rendered.
+	PostsDir string `yaml:"postsDir"`
 }
 
-func RenderPages(
-	ctx context.Context,
-	contentPaths ContentPaths,
-	commonData CommonPageData,
-	icons IconMap,
-	devMode bool,
-) error {
-	if err := validate.Struct(commonData); err != nil {
+type RenderOptions struct {
+	// If true, all pages are built even if some fail, and errors are reported together, grouped by
+	// content file (see [BuildErrors]). Otherwise, the build stops at the first error.
+	CollectErrors bool
+	// Defaults to UnknownKeysError if blank.
+	UnknownFrontmatterKeys UnknownKeyMode
+	// How pages are formatted as they are written, see [FormatHTML].
+	HTMLFormat HTMLFormat
+	// If true, image variants are planned but not encoded or written (see [ImagePipeline]).
+	SkipImageEncoding bool
+}
+
+// RenderConfig holds the inputs to [RenderPages] and [NewPageRenderer].
+type RenderConfig struct {
+	ContentPaths ContentPaths
+	CommonData   CommonPageData
+	Icons        IconMap
+	Images       ImageConfig
+	// Used to resolve paths to static assets in content and templates.
+	Assets *AssetManifest
+	Output OutputFS
+	// Used to skip rendering pages whose inputs have not changed since the previous build.
+	Cache   *BuildCache
+	Report  *BuildReport
+	Options RenderOptions
+}
+
+func RenderPages(ctx context.Context, config RenderConfig) error {
+	if err := validateStruct(config.CommonData); err != nil {
 		return ctxwrap.Errorf(ctx, err, "invalid common page data")
 	}
 
-	projectFiles, err := readProjectContentDirs(ctx, contentPaths.ProjectDirs)
-	if err != nil {
-		return err
+	if err := config.Output.MkdirAll("."); err != nil {
+		return ctxwrap.Error(ctx, err, "failed to create output directory")
 	}
 
-	renderer, err := NewPageRenderer(
-		commonData,
-		icons,
-		len(projectFiles),
-		len(contentPaths.BasicPages),
-		1,
-		devMode,
-	)
+	projectFiles, err := readContentDirs(ctx, config.ContentPaths.ProjectDirs)
 	if err != nil {
 		return err
 	}
-
-	group, ctx := errgroup.WithContext(ctx)
-
-	group.Go(renderer.RenderIcons)
-
-	for _, projectFile := range projectFiles {
-		group.Go(
-			func() error {
-				return renderer.RenderProjectPage(ctx, projectFile)
-			},
-		)
+	var postFiles []ContentFile
+	if config.ContentPaths.PostsDir != "" {
+		postFiles, err = readContentDirs(ctx, []string{config.ContentPaths.PostsDir})
+		if err != nil {
+			return err
+		}
+	}
+	// Feeds list posts, so they are left out of sites without posts, along with links to them
+	config.CommonData.hasFeeds = len(postFiles) != 0
+
+	if err := config.Cache.SetGlobalInputs(
+		config.CommonData,
+		config.Icons,
+		config.Assets,
+		config.Images,
+		config.Options.HTMLFormat,
+	); err != nil {
+		return ctxwrap.Error(ctx, err, "failed to hash build inputs")
 	}
 
-	group.Go(
-		func() error {
-			return renderer.RenderIndexPage(ctx, contentPaths.IndexPage)
-		},
+	images := NewImagePipeline(
+		config.Images,
+		config.Assets,
+		config.Output,
+		config.Cache,
+		!config.Options.SkipImageEncoding,
 	)
 
-	for _, basicPage := range contentPaths.BasicPages {
-		group.Go(
-			func() error {
-				return renderer.RenderBasicPage(ctx, basicPage)
-			},
-		)
+	renderer, err := NewPageRenderer(config, images)
+	if err != nil {
+		return err
 	}
 
-	group.Go(
-		func() error {
-			return renderer.BuildSitemap(ctx)
-		},
-	)
+	graph, err := renderer.NewBuildGraph(config.ContentPaths, projectFiles, postFiles)
+	if err != nil {
+		return ctxwrap.Error(ctx, err, "failed to set up build graph")
+	}
 
-	return group.Wait()
+	return graph.Run(ctx, config.Options.CollectErrors)
 }
 
 type PageRenderer struct {
 	commonData CommonPageData
 	templates  *template.Template
+	// Icons in this map are not rendered before the renderedIcons build output is produced.
+	icons IconMap
+	// Used to resolve paths to static assets in content and templates.
+	assets *AssetManifest
+	images *ImagePipeline
+	output OutputFS
+	// Used to skip rendering pages whose inputs have not changed since the previous build.
+	cache   *BuildCache
+	report  *BuildReport
+	options RenderOptions
+}
 
-	// Will be nil until projectGroupsParsed channel is closed.
-	parsedProjectGroups []ParsedProjectGroup
-	projectGroupsParsed chan struct{}
+func NewPageRenderer(config RenderConfig, images *ImagePipeline) (PageRenderer, error) {
+	templates, err := parseTemplates(config.Assets)
+	if err != nil {
+		return PageRenderer{}, err
+	}
 
-	parsedProjects chan ParsedProject
-	projectCount   int
+	return PageRenderer{
+		commonData: config.CommonData,
+		templates:  templates,
+		icons:      config.Icons,
+		assets:     config.Assets,
+		images:     images,
+		output:     config.Output,
+		cache:      config.Cache,
+		report:     config.Report,
+		options:    config.Options,
+	}, nil
+}
 
-	parsedPages chan Page
-	pageCount   int
+// NewBuildGraph sets up the stages for building every page in the site, the sitemap and the feeds.
+// To add a new kind of page, add its stages here, and add its page outputs to the list passed to
+// the sitemap stage.
+func (renderer *PageRenderer) NewBuildGraph(
+	contentPaths ContentPaths,
+	projectFiles []ContentFile,
+	postFiles []ContentFile,
+) (*BuildGraph, error) {
+	stages := []BuildStage{renderer.IconsStage()}
+	var pages []BuildOutput[Page]
 
-	// Icons in this map are not rendered before iconsRendered channel is closed.
-	icons         IconMap
-	iconsRendered chan struct{}
+	projects := make([]BuildOutput[ParsedProject], 0, len(projectFiles))
+	for _, projectFile := range projectFiles {
+		stage, project, page := renderer.ProjectPageStage(projectFile)
+		stages = append(stages, stage)
+		projects = append(projects, project)
+		pages = append(pages, page)
+	}
 
-	devMode bool
-}
+	var posts []BuildOutput[ParsedPost]
+	var pageLists []BuildOutput[[]Page]
+	if contentPaths.PostsDir != "" {
+		postStages, parsedPosts, postPages, postListPages := renderer.PostStages(postFiles)
+		stages = append(stages, postStages...)
+		posts = parsedPosts
+		pages = append(pages, postPages...)
+		pageLists = append(pageLists, postListPages)
+	}
 
-func NewPageRenderer(
-	commonData CommonPageData,
-	icons IconMap,
-	projectCount int,
-	basicPageCount int,
-	otherPagesCount int,
-	devMode bool,
-) (PageRenderer, error) {
-	templates, err := parseTemplates()
-	if err != nil {
-		return PageRenderer{}, err
+	indexPageStages, indexPage := renderer.IndexPageStages(contentPaths.IndexPage, projects)
+	stages = append(stages, indexPageStages...)
+	pages = append(pages, indexPage)
+
+	for _, basicPage := range contentPaths.BasicPages {
+		stage, page := renderer.BasicPageStage(basicPage)
+		stages = append(stages, stage)
+		pages = append(pages, page)
 	}
 
-	parsedProjects := make(chan ParsedProject, projectCount)
+	// Page bundles are added to the asset manifest and images are processed while pages are parsed
+	stages = append(stages, renderer.AssetsStage(pages))
 
-	pageCount := basicPageCount + projectCount + otherPagesCount
-	pagePaths := make(chan Page, pageCount)
+	techPagesStage, techPages := renderer.TechPagesStage(projects)
+	stages = append(stages, techPagesStage)
+	pageLists = append(pageLists, techPages)
 
-	return PageRenderer{
-		commonData:          commonData,
-		templates:           templates,
-		parsedProjectGroups: nil,
-		projectGroupsParsed: make(chan struct{}),
-		parsedProjects:      parsedProjects,
-		projectCount:        projectCount,
-		parsedPages:         pagePaths,
-		pageCount:           pageCount,
-		icons:               icons,
-		iconsRendered:       make(chan struct{}),
-		devMode:             devMode,
-	}, nil
+	stages = append(stages, renderer.SitemapStage(pages, pageLists))
+	if renderer.commonData.hasFeeds {
+		stages = append(stages, renderer.FeedsStage(projects, posts))
+	}
+
+	graph := NewBuildGraph()
+	for _, stage := range stages {
+		if err := graph.AddStage(stage); err != nil {
+			return nil, err
+		}
+	}
+	return graph, nil
 }
 
-func FormatRenderedPages(ctx context.Context) error {
-	patternToFormat := fmt.Sprintf("%s/**/*.html", BaseOutputDir)
-	return ExecCommand(ctx, false, "npx", "prettier", "--write", patternToFormat)
+// Creates a build output for the [Page] parsed from the given content file, so that the sitemap
+// can list all pages.
+func newPageOutput(contentPath string) BuildOutput[Page] {
+	return NewBuildOutput[Page](fmt.Sprintf("page from '%s'", contentPath))
 }
 
-func GenerateTailwindCSS(ctx context.Context, cssFileName string) error {
-	outputPath := fmt.Sprintf("%s/%s", BaseOutputDir, cssFileName)
-	return ExecCommand(
+// GenerateTailwindCSS generates CSS for the classes used in rendered pages. Skipped if no pages
+// changed in this build, and the input CSS file is unchanged since the previous build.
+func GenerateTailwindCSS(
+	ctx context.Context,
+	cssFileName string,
+	output DiskOutput,
+	cache *BuildCache,
+) error {
+	outputPath := CSSOutputPath(cssFileName)
+
+	hasher := sha256.New()
+	if err := hashFile(hasher, cssFileName); err != nil {
+		return ctxwrap.Error(ctx, err, "failed to hash input CSS file")
+	}
+	inputHash := hex.EncodeToString(hasher.Sum(nil))
+
+	if len(cache.ChangedFilesWithSuffix(".html")) == 0 &&
+		cache.isFresh(output, outputPath, inputHash) {
+		cache.recordOutput(outputPath, inputHash, false)
+		return nil
+	}
+
+	if err := ExecCommand(
 		ctx,
 		false,
 		"npx",
@@ -174,9 +263,20 @@ func GenerateTailwindCSS(ctx context.Context, cssFileName string) error {
 		"-i",
 		cssFileName,
 		"-o",
-		outputPath,
+		output.DiskPath(outputPath),
 		"--minify",
-	)
+	); err != nil {
+		return err
+	}
+
+	cache.recordOutput(outputPath, inputHash, true)
+	return nil
+}
+
+// CSSOutputPath returns the path in the output directory that CSS generated from the given input
+// CSS file is written to.
+func CSSOutputPath(cssFileName string) string {
+	return path.Base(cssFileName)
 }
 
 func ExecCommand(ctx context.Context, printOutput bool, commandName string, args ...string) error {
@@ -196,7 +296,6 @@ func ExecCommand(ctx context.Context, printOutput bool, commandName string, args
 
 	if printOutput {
 		command.Stdout = os.Stdout
-		command.Stderr = os.Stderr
 	}
 
 	if err := command.Start(); err != nil {
@@ -206,6 +305,10 @@ func ExecCommand(ctx context.Context, printOutput bool, commandName string, args
 	errScanner := bufio.NewScanner(stderr)
 	var commandErrs strings.Builder
 	for errScanner.Scan() {
+		// We read error output ourselves, so we can include it in the returned error
+		if printOutput {
+			_, _ = fmt.Fprintln(os.Stderr, errScanner.Text())
+		}
 		if commandErrs.Len() != 0 {
 			commandErrs.WriteRune('\n')
 		}
@@ -224,8 +327,10 @@ func ExecCommand(ctx context.Context, printOutput bool, commandName string, args
 	return nil
 }
 
-func parseTemplates() (*template.Template, error) {
-	templates := template.New(ProjectPageTemplateName).Funcs(TemplateFunctions)
+func parseTemplates(assets *AssetManifest) (*template.Template, error) {
+	templates := template.New(ProjectPageTemplateName).
+		Funcs(TemplateFunctions).
+		Funcs(template.FuncMap{"asset": assets.Path})
 
 	pageTemplates := fmt.Sprintf("%s/*.tmpl", PageTemplatesDir)
 	templates, err := templates.ParseGlob(pageTemplates)
@@ -244,40 +349,71 @@ func parseTemplates() (*template.Template, error) {
 
 const sitemapFileName = "sitemap.txt"
 
-func (renderer *PageRenderer) BuildSitemap(ctx context.Context) (returnedErr error) {
-	pageURLs := make([]string, 0, renderer.pageCount)
-	for range renderer.pageCount {
-		select {
-		case page := <-renderer.parsedPages:
-			if page.Path != "/404.html" && page.RedirectPath == "" {
-				var url string
-				if page.Path == "/" {
-					url = renderer.commonData.BaseURL
-				} else {
-					url = renderer.commonData.BaseURL + page.Path
-				}
+// SitemapStage returns a build stage that lists the given pages in the sitemap. Stages that render
+// a variable number of pages (such as post listings) produce lists of pages, in pageLists.
+func (renderer *PageRenderer) SitemapStage(
+	pages []BuildOutput[Page],
+	pageLists []BuildOutput[[]Page],
+) BuildStage {
+	return BuildStage{
+		Name:        "build sitemap",
+		ContentFile: "",
+		Inputs:      append(OutputKeys(pages...), OutputKeys(pageLists...)...),
+		Outputs:     nil,
+		Run: func(ctx context.Context, build BuildState) error {
+			allPages := GetAll(build, pages)
+			for _, pageList := range GetAll(build, pageLists) {
+				allPages = append(allPages, pageList...)
+			}
+			return renderer.BuildSitemap(ctx, allPages)
+		},
+	}
+}
 
-				pageURLs = append(pageURLs, url)
+func (renderer *PageRenderer) BuildSitemap(ctx context.Context, pages []Page) error {
+	pageURLs := make([]string, 0, len(pages))
+	for _, page := range pages {
+		if page.Path != "/404.html" && page.RedirectPath == "" {
+			var url string
+			if page.Path == "/" {
+				url = renderer.commonData.BaseURL
+			} else {
+				url = renderer.commonData.BaseURL + page.Path
 			}
-		case <-ctx.Done():
-			return nil
+
+			pageURLs = append(pageURLs, url)
 		}
 	}
 
 	slices.Sort(pageURLs)
 
-	sitemap := strings.Join(pageURLs, "\n")
+	sitemap := []byte(strings.Join(pageURLs, "\n") + "\n")
+	if err := renderer.writeGeneratedFile(ctx, sitemapFileName, sitemap); err != nil {
+		return ctxwrap.Error(ctx, err, "failed to write sitemap file")
+	}
+	return nil
+}
 
-	sitemapFile, err := os.Create(fmt.Sprintf("%s/%s", BaseOutputDir, sitemapFileName))
-	if err != nil {
-		return ctxwrap.Error(ctx, err, "failed to create sitemap file")
+// Writes a file that is generated from the content of other pages (such as the sitemap or feeds),
+// unless it is unchanged since the previous build. The file content itself is used as the input
+// hash, since it is cheap to generate.
+func (renderer *PageRenderer) writeGeneratedFile(
+	ctx context.Context,
+	fileName string,
+	content []byte,
+) error {
+	contentHash := sha256.Sum256(content)
+	inputHash := hex.EncodeToString(contentHash[:])
+	if renderer.cache.isFresh(renderer.output, fileName, inputHash) {
+		renderer.cache.recordOutput(fileName, inputHash, false)
+		return nil
 	}
-	defer errclose.Close(sitemapFile, &returnedErr, "sitemap file")
 
-	if _, err := fmt.Fprintln(sitemapFile, sitemap); err != nil {
-		return ctxwrap.Error(ctx, err, "failed to write to sitemap file")
+	if err := renderer.output.WriteFile(fileName, content); err != nil {
+		return ctxwrap.Errorf(ctx, err, "failed to write file '%s'", fileName)
 	}
 
+	renderer.cache.recordOutput(fileName, inputHash, true)
 	return nil
 }
 
@@ -310,10 +446,11 @@ func (renderer *PageRenderer) renderPageWithAndWithoutTrailingSlash(
 	ctx context.Context,
 	page Page,
 	data withPager,
+	source PageSource,
 ) error {
 	// If the page path ends with .html, then we only want to render it once.
 	if strings.HasSuffix(page.Path, ".html") {
-		return renderer.renderPage(ctx, page, data)
+		return renderer.renderPage(ctx, page, data, source)
 	}
 
 	if strings.HasSuffix(page.Path, "/") {
@@ -325,7 +462,7 @@ func (renderer *PageRenderer) renderPageWithAndWithoutTrailingSlash(
 	// Original page, without trailing slash
 	group.Go(
 		func() error {
-			return renderer.renderPage(ctx, page, data)
+			return renderer.renderPage(ctx, page, data, source)
 		},
 	)
 
@@ -334,49 +471,90 @@ func (renderer *PageRenderer) renderPageWithAndWithoutTrailingSlash(
 		func() error {
 			newPage := page
 			newPage.Path += "/"
-			// In production, we want to redirect pages with trailing slashes to pages without. But
-			// in dev, we use the live-server npm package for the dev server, which only works with
-			// trailing slashes. So we disable redirect if we're in dev mode.
-			if !renderer.devMode {
-				newPage.RedirectPath = page.Path
-			}
-			return renderer.renderPage(ctx, newPage, data.withPage(newPage))
+			newPage.RedirectPath = page.Path
+			return renderer.renderPage(ctx, newPage, data.withPage(newPage), source)
 		},
 	)
 
 	return group.Wait()
 }
 
+// Renders the page to its output file, unless the build cache shows that the file was already
+// rendered from the same inputs (see [BuildCache.hashPageInputs]). Adds the page to the build
+// report.
 func (renderer *PageRenderer) renderPage(
 	ctx context.Context,
 	page Page,
 	data any,
+	source PageSource,
 ) (returnedErr error) {
 	if page.CanonicalURL == "" {
 		return ctxwrap.NewError(ctx, "Page.CanonicalURL must be set before rendering")
 	}
 
-	outputPath, err := getRenderOutputPath(page.Path)
+	outputPath, err := getRenderOutputPath(renderer.output, page.Path)
 	if err != nil {
 		return err
 	}
 
-	outputFile, err := os.Create(outputPath)
-	if err != nil {
-		return ctxwrap.Errorf(ctx, err, "failed to create template output file '%s'", outputPath)
+	pageReport := PageReport{
+		Path:         page.Path,
+		ContentFile:  source.ContentFile,
+		Template:     page.TemplateName,
+		CanonicalURL: page.CanonicalURL,
+		RedirectPath: page.RedirectPath,
+		OutputPath:   outputPath,
+		Bytes:        0, // Set by BuildReport.Finish
+		Cached:       false,
+		ParseTime:    ReportDuration(source.ParseTime),
+		TemplateTime: 0,
+		FormatTime:   0,
+	}
+
+	if renderer.cache.isFresh(renderer.output, outputPath, source.InputHash) {
+		renderer.cache.recordOutput(outputPath, source.InputHash, false)
+		pageReport.Cached = true
+		renderer.report.addPage(pageReport)
+		return nil
 	}
-	defer errclose.Closef(outputFile, &returnedErr, "template output file %s", outputPath)
+	defer func() {
+		if returnedErr == nil {
+			renderer.cache.recordOutput(outputPath, source.InputHash, true)
+			renderer.report.addPage(pageReport)
+		}
+	}()
 
+	templateStart := time.Now()
+	var pageBuffer bytes.Buffer
 	if err := renderer.templates.ExecuteTemplate(
-		outputFile, page.TemplateName, data,
+		&pageBuffer, page.TemplateName, data,
 	); err != nil {
 		return ctxwrap.Errorf(ctx, err, "failed to execute template '%s'", page.TemplateName)
 	}
+	pageReport.TemplateTime = ReportDuration(time.Since(templateStart))
+
+	formatStart := time.Now()
+	pageHTML, err := FormatHTML(pageBuffer.Bytes(), renderer.options.HTMLFormat)
+	if err != nil {
+		return ctxwrap.Errorf(
+			ctx,
+			err,
+			"failed to format page rendered from '%s'",
+			page.TemplateName,
+		)
+	}
+	pageReport.FormatTime = ReportDuration(time.Since(formatStart))
+
+	if err := renderer.output.WriteFile(outputPath, pageHTML); err != nil {
+		return ctxwrap.Error(ctx, err, "failed to write rendered page")
+	}
 
 	return nil
 }
 
-func getRenderOutputPath(basePath string) (string, error) {
+// Returns the path of the page's output file, relative to the output root. Creates the parent
+// directory of the file if it does not exist.
+func getRenderOutputPath(output OutputFS, basePath string) (string, error) {
 	var dir string
 	var file string
 	if strings.HasSuffix(basePath, "/") {
@@ -404,31 +582,65 @@ func getRenderOutputPath(basePath string) (string, error) {
 		dir = strings.Join(dirs, "/")
 	}
 
-	dir = fmt.Sprintf("%s%s", BaseOutputDir, dir)
+	dir = strings.Trim(dir, "/")
 
-	permissions := fs.FileMode(0755)
-	if err := os.MkdirAll(dir, permissions); err != nil {
-		return "", wrap.Errorf(err, "failed to create template output directory '%s'", dir)
+	if dir != "" {
+		if err := output.MkdirAll(dir); err != nil {
+			return "", wrap.Errorf(err, "failed to create template output directory '%s'", dir)
+		}
 	}
 
-	return fmt.Sprintf("%s/%s", dir, file), nil
+	return path.Join(dir, file), nil
 }
 
-func readMarkdownWithFrontmatter(
+// Parses the YAML frontmatter of the given markdown file into frontmatterDest, and renders the rest
+// of the file as HTML to bodyDest. Returns the source of the frontmatter, to pass to
+// [validateYAML].
+//
+// If the file is in a page bundle, its files are added to the asset manifest after parsing the
+// frontmatter, so that the body can use them. The bundle is nil for other pages.
+//
+// Unknown keys in the frontmatter fail the parse, or log a warning, depending on
+// [RenderOptions.UnknownFrontmatterKeys].
+func (renderer *PageRenderer) readMarkdownWithFrontmatter(
 	ctx context.Context,
 	markdownFilePath string,
 	bodyDest io.Writer,
 	frontmatterDest any,
-) (returnedErr error) {
-	markdownFile, err := os.Open(markdownFilePath)
+	bundle *PageBundle,
+) (yamlSource, error) {
+	fileContent, err := os.ReadFile(markdownFilePath)
 	if err != nil {
-		return ctxwrap.Errorf(ctx, err, "failed to open file '%s'", markdownFilePath)
+		return yamlSource{}, ctxwrap.Errorf(ctx, err, "failed to read file '%s'", markdownFilePath)
 	}
-	defer errclose.Closef(markdownFile, &returnedErr, "file '%s'", markdownFilePath)
 
-	restOfFile, err := frontmatter.MustParse(markdownFile, frontmatterDest)
+	// The frontmatter parser does not skip a byte order mark, which some editors add
+	fileContent = bytes.TrimPrefix(fileContent, []byte(utf8ByteOrderMark))
+
+	var source yamlSource
+	var unknownKeys error
+	yamlFormat := frontmatter.NewFormat(
+		frontmatterDelimiter,
+		frontmatterDelimiter,
+		func(frontmatterBytes []byte, dest any) error {
+			var err error
+			source, unknownKeys, err = decodeYAML(
+				markdownFilePath,
+				frontmatterBytes,
+				frontmatterStartLine(fileContent),
+				dest,
+			)
+			return err
+		},
+	)
+
+	restOfFile, err := frontmatter.MustParse(
+		bytes.NewReader(fileContent),
+		frontmatterDest,
+		yamlFormat,
+	)
 	if err != nil {
-		return ctxwrap.Errorf(
+		return yamlSource{}, ctxwrap.Errorf(
 			ctx,
 			err,
 			"failed to parse markdown frontmatter of '%s'",
@@ -436,8 +648,33 @@ func readMarkdownWithFrontmatter(
 		)
 	}
 
-	if err := newMarkdownParser().Convert(restOfFile, bodyDest); err != nil {
-		return ctxwrap.Errorf(
+	if unknownKeys != nil {
+		if renderer.options.UnknownFrontmatterKeys == UnknownKeysWarn {
+			log.WarnError(ctx, unknownKeys, "Ignoring unknown frontmatter keys")
+		} else {
+			return yamlSource{}, ctxwrap.Errorf(
+				ctx,
+				unknownKeys,
+				"unknown keys in markdown frontmatter of '%s'",
+				markdownFilePath,
+			)
+		}
+	}
+
+	if bundle != nil {
+		if err := bundle.addAssets(renderer.assets); err != nil {
+			return yamlSource{}, ctxwrap.Errorf(
+				ctx,
+				err,
+				"failed to read files in page bundle of '%s'",
+				markdownFilePath,
+			)
+		}
+	}
+
+	markdown := newMarkdownParser(renderer.images, bundle)
+	if err := markdown.Convert(restOfFile, bodyDest); err != nil {
+		return yamlSource{}, ctxwrap.Errorf(
 			ctx,
 			err,
 			"failed to parse body of markdown file '%s'",
@@ -445,13 +682,39 @@ func readMarkdownWithFrontmatter(
 		)
 	}
 
-	return nil
+	return source, nil
+}
+
+const (
+	frontmatterDelimiter = "---"
+	utf8ByteOrderMark    = "\uFEFF"
+)
+
+// The frontmatter parser only gives us the frontmatter itself, so we find the line it starts on
+// the same way as the parser finds the opening delimiter: the first line that is not blank. The
+// frontmatter starts on the line after that.
+func frontmatterStartLine(fileContent []byte) int {
+	line := 1
+	for len(fileContent) != 0 {
+		var lineContent []byte
+		lineContent, fileContent, _ = bytes.Cut(fileContent, []byte("\n"))
+		line++
+
+		if len(bytes.TrimSpace(lineContent)) != 0 {
+			break
+		}
+	}
+	return line
 }
 
-func newMarkdownParser() goldmark.Markdown {
+// The bundle is used to resolve relative image paths, and may be nil for pages that are not page
+// bundles.
+func newMarkdownParser(images *ImagePipeline, bundle *PageBundle) goldmark.Markdown {
 	markdownOptions := goldmark.WithRendererOptions(
 		html.WithUnsafe(),
-		markdownrenderer.WithNodeRenderers(util.Prioritized(NewMarkdownRenderer(), 1)),
+		markdownrenderer.WithNodeRenderers(
+			util.Prioritized(NewMarkdownRenderer(images, bundle), 1),
+		),
 	)
 
 	return goldmark.New(markdownOptions)
@@ -0,0 +1,311 @@
+package sitebuilder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Content and static files for a small site, which is built with the templates in the repository.
+const testSiteDir = "testdata/site"
+
+func TestRenderPages(t *testing.T) {
+	output, _, assets := buildTestSite(t)
+
+	var pageFiles []string
+	if err := fs.WalkDir(
+		output,
+		".",
+		func(path string, _ fs.DirEntry, err error) error {
+			if err == nil && strings.HasSuffix(path, ".html") {
+				pageFiles = append(pageFiles, path)
+			}
+			return err
+		},
+	); err != nil {
+		t.Fatal(err)
+	}
+	expectedPageFiles := []string{
+		"404.html",
+		"example.html",
+		"example/index.html",
+		"index.html",
+		"posts.html",
+		"posts/2024.html",
+		"posts/2024/index.html",
+		"posts/first-post.html",
+		"posts/first-post/index.html",
+		"posts/index.html",
+		"tech/go.html",
+		"tech/go/index.html",
+		"tool.html",
+		"tool/index.html",
+	}
+	slices.Sort(pageFiles)
+	if !slices.Equal(pageFiles, expectedPageFiles) {
+		t.Errorf("expected pages:\n%v\ngot:\n%v", expectedPageFiles, pageFiles)
+	}
+
+	projectPage := readOutputFile(t, output, "example.html")
+	for _, expected := range []string{
+		"<title>example.dev/example</title>",
+		`<link rel="canonical" href="https://example.dev/example" />`,
+		"<p>An example project, with a screenshot from its page bundle.</p>",
+		"https://github.com/example/example",
+		`<a class="flex items-center gap-1" href="/tech/go">`,
+		"<figcaption class=\"italic text-center mb-1\">Screenshot of example</figcaption>",
+		`onload="this.style.removeProperty(&#39;background-image&#39;)"`,
+	} {
+		if !strings.Contains(projectPage, expected) {
+			t.Errorf("expected project page to contain %q, got:\n%s", expected, projectPage)
+		}
+	}
+
+	screenshotPath, err := assets.Path("content/projects/example/screenshot.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(screenshotPath, "/example/screenshot.") {
+		t.Errorf("expected page bundle image to be next to its page, got '%s'", screenshotPath)
+	}
+	expectSrcSetVariants(t, output, projectPage)
+	expectSrcSetVariants(t, output, readOutputFile(t, output, "index.html"))
+
+	notFoundPage := readOutputFile(t, output, "404.html")
+	if !strings.Contains(notFoundPage, "<pre>\n 4  0  4\n</pre>") {
+		t.Errorf("expected 404 page to keep <pre> content as-is, got:\n%s", notFoundPage)
+	}
+
+	sitemap := readOutputFile(t, output, sitemapFileName)
+	expectedSitemap := strings.Join(
+		[]string{
+			"https://example.dev",
+			"https://example.dev/example",
+			"https://example.dev/posts",
+			"https://example.dev/posts/2024",
+			"https://example.dev/posts/first-post",
+			"https://example.dev/tech/go",
+			"https://example.dev/tool",
+			"",
+		},
+		"\n",
+	)
+	if sitemap != expectedSitemap {
+		t.Errorf("expected sitemap:\n%s\ngot:\n%s", expectedSitemap, sitemap)
+	}
+
+	favicon := readOutputFile(t, output, "favicon.ico")
+	expectedFavicon, err := os.ReadFile(filepath.Join(BaseAssetsDir, "favicon.ico"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if favicon != string(expectedFavicon) {
+		t.Errorf("expected favicon to be copied as-is, got %q", favicon)
+	}
+
+	logoPath, err := assets.Path("/img/logo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(logoPath, "/img/logo.") || logoPath == "/img/logo.png" {
+		t.Errorf("expected fingerprinted logo path, got '%s'", logoPath)
+	}
+	readOutputFile(t, output, strings.TrimPrefix(logoPath, "/"))
+}
+
+func TestFrontmatterValidationErrorLocation(t *testing.T) {
+	frontmatter := []string{
+		"---",
+		"name: example",
+		"links:",
+		"  - title: Docs",
+		"    link: https://example.dev/docs",
+		"  - title: Code",
+		"    link: not a URL",
+		"---",
+		"",
+		// Repeats the start of the frontmatter, which should not be mistaken for it
+		"name: example",
+		"links:",
+		"",
+	}
+
+	testCases := []struct {
+		name         string
+		content      string
+		expectedLine int
+	}{
+		{
+			name:         "LF line endings",
+			content:      strings.Join(frontmatter, "\n"),
+			expectedLine: 7,
+		},
+		{
+			name:         "CRLF line endings",
+			content:      strings.Join(frontmatter, "\r\n"),
+			expectedLine: 7,
+		},
+		{
+			name:         "byte order mark and blank lines before frontmatter",
+			content:      "\uFEFF\n  \n" + strings.Join(frontmatter, "\n"),
+			expectedLine: 9,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "page.md")
+			if err := os.WriteFile(filePath, []byte(testCase.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			var dest testFrontmatter
+			//nolint:exhaustruct
+			renderer := PageRenderer{
+				options: RenderOptions{UnknownFrontmatterKeys: UnknownKeysError},
+			}
+			source, err := renderer.readMarkdownWithFrontmatter(
+				context.Background(),
+				filePath,
+				io.Discard,
+				&dest,
+				nil,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = validateYAML(dest, source)
+			expectedError := fmt.Sprintf(
+				"%s:%d: links[1].link must be a URL, got 'not a URL'",
+				filePath,
+				testCase.expectedLine,
+			)
+			if err == nil || err.Error() != expectedError {
+				t.Errorf("expected error %q, got %v", expectedError, err)
+			}
+		})
+	}
+}
+
+// Builds the site in [testSiteDir] into memory, with the same options as a production build.
+// Changes the working directory for the rest of the test, since content paths are relative to it.
+func buildTestSite(t *testing.T) (*MemoryOutput, *BuildReport, *AssetManifest) {
+	t.Helper()
+
+	setUpTestSite(t)
+	output := NewMemoryOutput()
+	report, assets := renderTestSite(t, output, NewBuildCache(""))
+	return output, report, assets
+}
+
+// Copies the site in [testSiteDir] and the templates in the repository to a temporary directory,
+// and changes the working directory to it for the rest of the test.
+func setUpTestSite(t *testing.T) {
+	t.Helper()
+
+	templatesDir, err := filepath.Abs(filepath.Join("..", "templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	siteDir := t.TempDir()
+	if err := os.CopyFS(siteDir, os.DirFS(testSiteDir)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.CopyFS(filepath.Join(siteDir, "templates"), os.DirFS(templatesDir)); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(siteDir)
+}
+
+// Renders the site in the working directory (see [setUpTestSite]) to the given output.
+func renderTestSite(
+	t *testing.T,
+	output *MemoryOutput,
+	cache *BuildCache,
+) (*BuildReport, *AssetManifest) {
+	t.Helper()
+
+	report, assets, err := tryRenderTestSite(t, output, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report, assets
+}
+
+// Like [renderTestSite], but returns errors from rendering instead of failing the test, for tests
+// of invalid content.
+func tryRenderTestSite(
+	t *testing.T,
+	output *MemoryOutput,
+	cache *BuildCache,
+) (*BuildReport, *AssetManifest, error) {
+	t.Helper()
+
+	ctx := context.Background()
+	config, err := LoadSiteConfig(ctx, DefaultSiteConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assets, err := LoadAssetManifest(ctx, config.InputCSSFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := NewBuildReport()
+	if err := RenderPages(ctx, RenderConfig{
+		ContentPaths: config.ContentPaths,
+		CommonData:   config.CommonPageData,
+		Icons:        config.Icons,
+		Images:       config.Images,
+		Assets:       assets,
+		Output:       output,
+		Cache:        cache,
+		Report:       report,
+		Options: RenderOptions{
+			CollectErrors:          true,
+			UnknownFrontmatterKeys: config.UnknownFrontmatterKeys,
+			HTMLFormat:             config.HTMLFormat,
+			SkipImageEncoding:      false,
+		},
+	}); err != nil {
+		return nil, nil, err
+	}
+	if err := report.Finish(ctx, output); err != nil {
+		t.Fatal(err)
+	}
+
+	return report, assets, nil
+}
+
+// Checks that the page has an image with a srcset, and that all variants in the first srcset were
+// written to the output.
+func expectSrcSetVariants(t *testing.T, output fs.FS, page string) {
+	t.Helper()
+
+	srcSet := regexp.MustCompile(`srcset="([^"]+)"`).FindStringSubmatch(page)
+	if srcSet == nil {
+		t.Fatalf("expected srcset in page, got:\n%s", page)
+	}
+	for _, variant := range strings.Split(srcSet[1], ", ") {
+		variantPath, _, _ := strings.Cut(variant, " ")
+		readOutputFile(t, output, strings.TrimPrefix(variantPath, "/"))
+	}
+}
+
+func readOutputFile(t *testing.T, output fs.FS, path string) string {
+	t.Helper()
+
+	content, err := fs.ReadFile(output, path)
+	if err != nil {
+		t.Fatalf("expected output file '%s': %v", path, err)
+	}
+	return string(content)
+}
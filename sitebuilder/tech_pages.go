@@ -0,0 +1,240 @@
+package sitebuilder
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"hermannm.dev/wrap/ctxwrap"
+)
+
+const (
+	TechPageTemplateName = "tech_page.html.tmpl"
+	// Each technology used in a project's tech stack gets a page at /tech/<name>, listing the
+	// projects that use it.
+	TechPagesPath = "/tech"
+)
+
+type TechPageTemplate struct {
+	Meta TemplateMetadata
+	Name string
+	Icon template.HTML
+	// External link to the technology's website, from [IconConfig.Link]. Blank if not configured.
+	Link string
+	// Sorted by name.
+	Projects []ProjectProfile
+}
+
+// TechPagesStage returns a build stage that renders a page for each technology used in the given
+// projects. The pages are produced as a single output, since which technologies get pages depends
+// on the parsed projects.
+func (renderer *PageRenderer) TechPagesStage(
+	projects []BuildOutput[ParsedProject],
+) (stage BuildStage, pages BuildOutput[[]Page]) {
+	pages = NewBuildOutput[[]Page]("tech pages")
+
+	stage = BuildStage{
+		Name:        "render tech pages",
+		ContentFile: "",
+		Inputs:      append(OutputKeys(projects...), renderedIcons),
+		Outputs:     []BuildOutputKey{pages},
+		Run: func(ctx context.Context, build BuildState) error {
+			renderedPages, err := renderer.RenderTechPages(
+				ctx,
+				GetAll(build, projects),
+				renderedIcons.Get(build),
+			)
+			if err != nil {
+				return err
+			}
+			pages.Set(build, renderedPages)
+			return nil
+		},
+	}
+
+	return stage, pages
+}
+
+// RenderTechPages renders a page for every technology in the tech stacks of the given projects
+// (both [TechStackItemMarkdown.Tech] and [TechStackItemMarkdown.UsedWith]). Returns the rendered
+// pages.
+func (renderer *PageRenderer) RenderTechPages(
+	ctx context.Context,
+	projects []ParsedProject,
+	icons IconMap,
+) ([]Page, error) {
+	projectsByTech := make(map[string][]ParsedProject)
+	for _, project := range projects {
+		var techNames []string
+		for _, tech := range project.TechStack {
+			techNames = append(techNames, tech.LinkText)
+			for _, usedWith := range tech.UsedWith {
+				techNames = append(techNames, usedWith.LinkText)
+			}
+		}
+
+		// A project may list the same technology more than once, e.g. as used with two others
+		slices.Sort(techNames)
+		for _, techName := range slices.Compact(techNames) {
+			projectsByTech[techName] = append(projectsByTech[techName], project)
+		}
+	}
+
+	// Technologies whose names only differ in case or punctuation would get the same path
+	techNamesByPath := make(map[string]string, len(projectsByTech))
+	for techName := range projectsByTech {
+		pagePath, err := techPagePath(techName)
+		if err != nil {
+			return nil, ctxwrap.Error(ctx, err, "invalid technology name")
+		}
+		if otherTechName, ok := techNamesByPath[pagePath]; ok {
+			return nil, ctxwrap.NewErrorf(
+				ctx,
+				"technologies '%s' and '%s' would both have tech page at '%s'",
+				min(techName, otherTechName),
+				max(techName, otherTechName),
+				pagePath,
+			)
+		}
+		techNamesByPath[pagePath] = techName
+	}
+
+	pages := make([]Page, 0, len(projectsByTech))
+	for _, pagePath := range slices.Sorted(maps.Keys(techNamesByPath)) {
+		techName := techNamesByPath[pagePath]
+		page, err := renderer.renderTechPage(
+			ctx,
+			techName,
+			pagePath,
+			projectsByTech[techName],
+			icons,
+		)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+
+	return pages, nil
+}
+
+func (renderer *PageRenderer) renderTechPage(
+	ctx context.Context,
+	techName string,
+	pagePath string,
+	projects []ParsedProject,
+	icons IconMap,
+) (Page, error) {
+	parseStart := time.Now()
+
+	// Already checked by parseTechStack
+	techIcon, ok := icons[techName]
+	if !ok {
+		return Page{}, ctxwrap.NewErrorf(ctx, "failed to find icon for technology '%s'", techName)
+	}
+
+	page := Page{
+		Title:        renderer.commonData.SiteName + pagePath,
+		Path:         pagePath,
+		TemplateName: TechPageTemplateName,
+		RedirectPath: "",
+		CanonicalURL: "",
+		GoPackage:    nil,
+	}
+	page.SetCanonicalURL(renderer.commonData.BaseURL)
+
+	projects = slices.Clone(projects)
+	slices.SortFunc(
+		projects,
+		func(project1 ParsedProject, project2 ParsedProject) int {
+			return strings.Compare(project1.Name, project2.Name)
+		},
+	)
+
+	techPage := TechPageTemplate{
+		Meta:     TemplateMetadata{Common: renderer.commonData, Page: page},
+		Name:     techName,
+		Icon:     techIcon.RenderedIcon,
+		Link:     techIcon.Link,
+		Projects: make([]ProjectProfile, 0, len(projects)),
+	}
+	// The tech page shows each project's name, tag line and logo, so it must be re-rendered when
+	// one of its projects changes
+	projectInputHashes := make([]string, 0, len(projects))
+	for _, project := range projects {
+		techPage.Projects = append(techPage.Projects, project.ProjectProfile)
+		projectInputHashes = append(projectInputHashes, project.Source.InputHash)
+	}
+
+	inputHash, err := renderer.cache.hashPageInputs(
+		page.TemplateName,
+		nil,
+		append([]string{techName}, projectInputHashes...)...,
+	)
+	if err != nil {
+		return Page{}, ctxwrap.Error(ctx, err, "failed to hash tech page inputs")
+	}
+
+	source := PageSource{ContentFile: "", InputHash: inputHash, ParseTime: time.Since(parseStart)}
+	if err := renderer.renderPageWithAndWithoutTrailingSlash(
+		ctx,
+		page,
+		techPage,
+		source,
+	); err != nil {
+		return Page{}, ctxwrap.Errorf(ctx, err, "failed to render tech page for '%s'", techName)
+	}
+
+	return page, nil
+}
+
+// Returns the path of the tech page for the given technology, with the name lowercased and
+// punctuation replaced, e.g. "/tech/postgresql" for "PostgreSQL" and "/tech/c-sharp" for "C#".
+// Fails if the name has no letters or digits to make a path from.
+func techPagePath(techName string) (string, error) {
+	var slug strings.Builder
+	for _, char := range strings.ToLower(techName) {
+		switch {
+		case char == '#':
+			writeSlugWord(&slug, "sharp")
+		case char == '+':
+			writeSlugWord(&slug, "plus")
+		case unicode.IsLetter(char) || unicode.IsDigit(char):
+			slug.WriteRune(char)
+		default:
+			writeSlugWord(&slug, "")
+		}
+	}
+
+	slugString := strings.TrimSuffix(slug.String(), "-")
+	if slugString == "" {
+		return "", fmt.Errorf(
+			"technology name '%s' has no letters or digits to make a tech page path from",
+			techName,
+		)
+	}
+	return TechPagesPath + "/" + slugString, nil
+}
+
+// Separates the given word from the rest of the slug with dashes, without repeating dashes. A blank
+// word just adds a separator.
+func writeSlugWord(slug *strings.Builder, word string) {
+	if slug.Len() != 0 && !strings.HasSuffix(slug.String(), "-") {
+		slug.WriteByte('-')
+	}
+	if word != "" {
+		slug.WriteString(word)
+		slug.WriteByte('-')
+	}
+}
+
+// Implements [withPager] to work with [PageRenderer.renderPageWithAndWithoutTrailingSlash].
+func (template TechPageTemplate) withPage(page Page) any {
+	template.Meta.Page = page
+	return template
+}
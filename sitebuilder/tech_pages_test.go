@@ -0,0 +1,67 @@
+package sitebuilder
+
+import "testing"
+
+func TestTechPagePath(t *testing.T) {
+	testCases := []struct {
+		techName      string
+		expectedPath  string
+		expectedError string
+	}{
+		{techName: "Go", expectedPath: "/tech/go", expectedError: ""},
+		{techName: "PostgreSQL", expectedPath: "/tech/postgresql", expectedError: ""},
+		{techName: "C#", expectedPath: "/tech/c-sharp", expectedError: ""},
+		{techName: "C++", expectedPath: "/tech/c-plus-plus", expectedError: ""},
+		{techName: "F#", expectedPath: "/tech/f-sharp", expectedError: ""},
+		{techName: ".NET", expectedPath: "/tech/net", expectedError: ""},
+		{techName: "ASP.NET Core", expectedPath: "/tech/asp-net-core", expectedError: ""},
+		{techName: "Node.js", expectedPath: "/tech/node-js", expectedError: ""},
+		{techName: "GitHub Actions", expectedPath: "/tech/github-actions", expectedError: ""},
+		{techName: "  Spaces  around ", expectedPath: "/tech/spaces-around", expectedError: ""},
+		{techName: "Go 1.23", expectedPath: "/tech/go-1-23", expectedError: ""},
+		{techName: "Blåbær", expectedPath: "/tech/blåbær", expectedError: ""},
+		{techName: "#", expectedPath: "/tech/sharp", expectedError: ""},
+		{
+			techName:     "...",
+			expectedPath: "",
+			expectedError: "technology name '...' has no letters or digits to make a tech page " +
+				"path from",
+		},
+		{
+			techName:     " - ",
+			expectedPath: "",
+			expectedError: "technology name ' - ' has no letters or digits to make a tech page " +
+				"path from",
+		},
+		{
+			techName:     "",
+			expectedPath: "",
+			expectedError: "technology name '' has no letters or digits to make a tech page " +
+				"path from",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.techName, func(t *testing.T) {
+			path, err := techPagePath(testCase.techName)
+			if testCase.expectedError != "" {
+				if err == nil || err.Error() != testCase.expectedError {
+					t.Errorf(
+						"expected error %q, got path '%s' and error %v",
+						testCase.expectedError,
+						path,
+						err,
+					)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if path != testCase.expectedPath {
+				t.Errorf("expected path '%s', got '%s'", testCase.expectedPath, path)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package sitebuilder
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"hermannm.dev/wrap"
+
+	"github.com/go-playground/validator/v10"
+)
+
+var validate = newValidator()
+
+// Used as the name of inline YAML fields in validation errors, so we can remove them from the key
+// path (since they are not part of the YAML structure).
+const inlineFieldName = ",inline"
+
+func newValidator() *validator.Validate {
+	validate := validator.New()
+
+	// Uses YAML key names in validation errors, so that they match what the user wrote
+	validate.RegisterTagNameFunc(
+		func(field reflect.StructField) string {
+			yamlTag := field.Tag.Get("yaml")
+			if strings.Contains(yamlTag, ",inline") {
+				return inlineFieldName
+			}
+			name, _, _ := strings.Cut(yamlTag, ",")
+			if name == "-" {
+				return ""
+			}
+			return name
+		},
+	)
+
+	return validate
+}
+
+// validateStruct validates the given struct using the `validate` tags on its fields. If validation
+// fails, the returned error lists a message for each invalid field, with the field's YAML key path
+// (e.g. "links[2].link must be a URL").
+func validateStruct(value any) error {
+	return validateFields(value, nil)
+}
+
+// validateYAML validates a struct that was decoded from the given YAML source, like
+// [validateStruct], but prefixes each message with the file path and line of the field (e.g.
+// "content/projects/example.md:12: links[2].link must be a URL"). Most editors and terminals let
+// you jump straight to the field from this format.
+func validateYAML(value any, source yamlSource) error {
+	return validateFields(value, &source)
+}
+
+func validateFields(value any, source *yamlSource) error {
+	err := validate.Struct(value)
+	if err == nil {
+		return nil
+	}
+
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return err
+	}
+
+	messages := make([]error, 0, len(fieldErrs))
+	for _, fieldErr := range fieldErrs {
+		message := validationErrorMessage(fieldErr)
+		if source != nil {
+			message = fmt.Sprintf("%s: %s", source.location(validationErrorKey(fieldErr)), message)
+		}
+		messages = append(messages, errors.New(message))
+	}
+	if len(messages) == 1 {
+		return messages[0]
+	}
+	// Uses wrap.Errors instead of errors.Join, so that the messages are logged as a list
+	return wrap.Errorsf(messages, "%d fields are invalid", len(messages))
+}
+
+func validationErrorMessage(fieldErr validator.FieldError) string {
+	key := validationErrorKey(fieldErr)
+
+	switch fieldErr.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", key)
+	case "required_without":
+		// The param is the Go name of the other field, so we convert it to the YAML key convention
+		otherKey := strings.ToLower(fieldErr.Param()[:1]) + fieldErr.Param()[1:]
+		return fmt.Sprintf("%s is required when %s is not set", key, otherKey)
+	case "url":
+		return fmt.Sprintf("%s must be a URL, got '%v'", key, fieldErr.Value())
+	case "filepath":
+		return fmt.Sprintf("%s must be a file path, got '%v'", key, fieldErr.Value())
+	case "oneof":
+		options := strings.ReplaceAll(fieldErr.Param(), " ", "', '")
+		return fmt.Sprintf("%s must be one of '%s', got '%v'", key, options, fieldErr.Value())
+	case "startswith":
+		return fmt.Sprintf("%s must start with '%s'", key, fieldErr.Param())
+	default:
+		return fmt.Sprintf("%s failed '%s' validation", key, fieldErr.Tag())
+	}
+}
+
+// Returns the YAML key path of the field, without the name of the root struct.
+func validationErrorKey(fieldErr validator.FieldError) string {
+	key := strings.ReplaceAll(fieldErr.Namespace(), inlineFieldName+".", "")
+	_, key, _ = strings.Cut(key, ".")
+	return key
+}
@@ -0,0 +1,311 @@
+package sitebuilder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"hermannm.dev/wrap"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlSource is the location of YAML that a struct was decoded from (a markdown file's frontmatter,
+// or the site config), used to point errors to the line of the field they refer to.
+type yamlSource struct {
+	filePath string
+	// Line number in the file of the first line of YAML. For frontmatter, this is the line after
+	// the opening '---'.
+	startLine int
+	// Maps YAML key paths to line numbers in the file, with the same key format as validation
+	// errors (e.g. "links[2].link").
+	keyLines map[string]int
+}
+
+// Decodes the given YAML into dest, and returns its source, with the key paths and line numbers
+// of the YAML. The YAML is parsed into a node tree for line numbers, and decoded with unknown keys
+// rejected ([yaml.Node.Decode] has no option for this, so we use a [yaml.Decoder]).
+//
+// Unknown keys do not stop decoding, so they are returned separately from other errors, for the
+// caller to decide whether they should fail (see [UnknownKeyMode]).
+//
+// startLine is the line number in the file of the first line of YAML (see [yamlSource]). Line
+// numbers in decoding errors are adjusted by it.
+func decodeYAML(
+	filePath string,
+	yamlBytes []byte,
+	startLine int,
+	dest any,
+) (source yamlSource, unknownKeys error, err error) {
+	source = yamlSource{filePath: filePath, startLine: startLine, keyLines: make(map[string]int)}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(yamlBytes, &document); err != nil {
+		return yamlSource{}, nil, source.fixErrorLines(err)
+	}
+
+	walkYAML(&document, "", document.Line, func(keyPath string, line int, _ *yaml.Node) {
+		if keyPath != "" {
+			source.keyLines[keyPath] = source.fileLine(line)
+		}
+	})
+
+	decoder := yaml.NewDecoder(bytes.NewReader(yamlBytes))
+	decoder.KnownFields(true)
+	// Empty YAML gives io.EOF, which leaves dest empty like yaml.Unmarshal
+	if err := decoder.Decode(dest); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return yamlSource{}, nil, source.fixErrorLines(err)
+		}
+
+		unknownKeys, otherErrors := source.splitUnknownKeyErrors(typeErr.Errors, dest)
+		if len(otherErrors) != 0 {
+			return yamlSource{}, nil, source.fixErrorLines(&yaml.TypeError{Errors: otherErrors})
+		}
+		return source, unknownKeys, nil
+	}
+
+	return source, nil, nil
+}
+
+// Calls visit for every node in the given YAML node tree, with its key path in the same format as
+// validation errors (e.g. "links[2].link"). For mapping values, the line is that of their key,
+// which is where the field starts.
+func walkYAML(
+	node *yaml.Node,
+	keyPath string,
+	line int,
+	visit func(keyPath string, line int, node *yaml.Node),
+) {
+	if node.Kind == yaml.DocumentNode {
+		for _, child := range node.Content {
+			walkYAML(child, keyPath, child.Line, visit)
+		}
+		return
+	}
+
+	visit(keyPath, line, node)
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		// Mapping node content alternates between keys and values
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			childPath := key.Value
+			if keyPath != "" {
+				childPath = keyPath + "." + key.Value
+			}
+			walkYAML(value, childPath, key.Line, visit)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			walkYAML(item, fmt.Sprintf("%s[%d]", keyPath, i), item.Line, visit)
+		}
+	case yaml.DocumentNode, yaml.ScalarNode, yaml.AliasNode:
+	}
+}
+
+// Returns "<file path>:<line>" for the given YAML key path. If the key is not in the source (for
+// example a required field that is missing), we use the line of the closest parent key that is,
+// or the start of the YAML if there is none.
+func (source yamlSource) location(keyPath string) string {
+	for keyPath != "" {
+		if line, ok := source.keyLines[keyPath]; ok {
+			return fmt.Sprintf("%s:%d", source.filePath, line)
+		}
+
+		parentEnd := strings.LastIndexAny(keyPath, ".[")
+		if parentEnd == -1 {
+			break
+		}
+		keyPath = keyPath[:parentEnd]
+	}
+
+	return fmt.Sprintf("%s:%d", source.filePath, source.startLine)
+}
+
+func (source yamlSource) fileLine(yamlLine int) int {
+	return source.startLine + yamlLine - 1
+}
+
+var yamlErrorLinePattern = regexp.MustCompile(`line (\d+)`)
+
+// YAML decoding errors refer to line numbers within the decoded YAML. For frontmatter, this
+// replaces them with line numbers in the markdown file, so that they point to the right line.
+func (source yamlSource) fixErrorLines(err error) error {
+	if source.startLine == 1 {
+		return err
+	}
+
+	message := yamlErrorLinePattern.ReplaceAllStringFunc(
+		err.Error(),
+		func(match string) string {
+			yamlLine, parseErr := strconv.Atoi(strings.TrimPrefix(match, "line "))
+			if parseErr != nil {
+				return match
+			}
+			return fmt.Sprintf("line %d", source.fileLine(yamlLine))
+		},
+	)
+	return yamlError{message: message, wrapped: err}
+}
+
+// Keeps the original YAML error available to errors.As, while showing corrected line numbers.
+type yamlError struct {
+	message string
+	wrapped error
+}
+
+func (err yamlError) Error() string {
+	return err.message
+}
+
+func (err yamlError) Unwrap() error {
+	return err.wrapped
+}
+
+// UnknownKeyMode configures how unknown keys in frontmatter are handled.
+type UnknownKeyMode string
+
+const (
+	// Fails the build for the page with unknown keys. The default.
+	UnknownKeysError UnknownKeyMode = "error"
+	// Logs a warning, and ignores the unknown keys.
+	UnknownKeysWarn UnknownKeyMode = "warn"
+)
+
+var unknownKeyErrorPattern = regexp.MustCompile(`^line (\d+): field (.+) not found in type \S+$`)
+
+// Separates errors for unknown keys (from [yaml.Decoder.KnownFields]) from the given decoding
+// errors. Unknown keys are returned as a single error, with the closest valid key name as a
+// suggestion (so that a typo like "tagline" suggests "tagLine").
+func (source yamlSource) splitUnknownKeyErrors(
+	decodeErrors []string,
+	dest any,
+) (unknownKeys error, otherErrors []string) {
+	var errs []error
+	for _, decodeError := range decodeErrors {
+		match := unknownKeyErrorPattern.FindStringSubmatch(decodeError)
+		if match == nil {
+			otherErrors = append(otherErrors, decodeError)
+			continue
+		}
+		yamlLine, err := strconv.Atoi(match[1])
+		if err != nil {
+			otherErrors = append(otherErrors, decodeError)
+			continue
+		}
+		key := match[2]
+
+		keyPath, ok := source.keyPathAt(source.fileLine(yamlLine), key)
+		if !ok {
+			errs = append(errs, fmt.Errorf(
+				"%s:%d: unknown key '%s'",
+				source.filePath,
+				source.fileLine(yamlLine),
+				key,
+			))
+			continue
+		}
+
+		message := fmt.Sprintf("%s: unknown key '%s'", source.location(keyPath), keyPath)
+		parentPath := strings.TrimSuffix(strings.TrimSuffix(keyPath, key), ".")
+		if suggestion := closestKey(key, validYAMLKeys(dest, parentPath)); suggestion != "" {
+			message += fmt.Sprintf(" (did you mean '%s'?)", suggestion)
+		}
+		errs = append(errs, errors.New(message))
+	}
+
+	switch len(errs) {
+	case 0:
+		return nil, otherErrors
+	case 1:
+		return errs[0], otherErrors
+	default:
+		return wrap.Errorsf(errs, "%d unknown keys", len(errs)), otherErrors
+	}
+}
+
+// Returns the path of the given key on the given line. Decoding errors only give the key name, and
+// keys in flow mappings may share a line, so we need both to find the path.
+func (source yamlSource) keyPathAt(line int, key string) (keyPath string, ok bool) {
+	for _, keyPath := range sortedKeys(source.keyLines) {
+		isKey := keyPath == key || strings.HasSuffix(keyPath, "."+key)
+		if isKey && source.keyLines[keyPath] == line {
+			return keyPath, true
+		}
+	}
+	return "", false
+}
+
+// Returns the keys of the mapping at the given key path, when encoding the given decoded value
+// back to YAML. This gives the valid keys at that path by the decoder's own rules (for inline
+// structs, omitted fields and so on).
+func validYAMLKeys(decoded any, keyPath string) []string {
+	var encoded yaml.Node
+	if err := encoded.Encode(decoded); err != nil {
+		return nil
+	}
+
+	var keys []string
+	walkYAML(&encoded, "", encoded.Line, func(path string, _ int, node *yaml.Node) {
+		if path == keyPath && node.Kind == yaml.MappingNode {
+			for i := 0; i < len(node.Content); i += 2 {
+				keys = append(keys, node.Content[i].Value)
+			}
+		}
+	})
+	return keys
+}
+
+// Returns the valid key with the smallest edit distance to the given key, ignoring case. Returns a
+// blank string if no key is close enough to likely be what was meant.
+func closestKey(key string, validKeys []string) string {
+	closest := ""
+	closestDistance := 0
+	for _, validKey := range validKeys {
+		distance := editDistance(strings.ToLower(key), strings.ToLower(validKey))
+		if closest == "" || distance < closestDistance {
+			closest = validKey
+			closestDistance = distance
+		}
+	}
+
+	maxDistance := max(2, len(key)/3)
+	if closestDistance > maxDistance {
+		return ""
+	}
+	return closest
+}
+
+// Levenshtein distance between the two strings.
+func editDistance(string1 string, string2 string) int {
+	previousRow := make([]int, len(string2)+1)
+	currentRow := make([]int, len(string2)+1)
+	for j := range previousRow {
+		previousRow[j] = j
+	}
+
+	for i := 1; i <= len(string1); i++ {
+		currentRow[0] = i
+		for j := 1; j <= len(string2); j++ {
+			substitutionCost := 1
+			if string1[i-1] == string2[j-1] {
+				substitutionCost = 0
+			}
+			currentRow[j] = min(
+				previousRow[j]+1,
+				currentRow[j-1]+1,
+				previousRow[j-1]+substitutionCost,
+			)
+		}
+		previousRow, currentRow = currentRow, previousRow
+	}
+
+	return previousRow[len(string2)]
+}
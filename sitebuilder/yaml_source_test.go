@@ -0,0 +1,205 @@
+package sitebuilder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testFrontmatter struct {
+	Name  string                `yaml:"name"  validate:"required"`
+	Logo  testFrontmatterLogo   `yaml:"logo"`
+	Links []testFrontmatterLink `yaml:"links" validate:"dive"`
+}
+
+type testFrontmatterLogo struct {
+	Path    string `yaml:"path"`
+	AltText string `yaml:"altText"`
+}
+
+type testFrontmatterLink struct {
+	Title string `yaml:"title" validate:"required"`
+	Link  string `yaml:"link"  validate:"required,url"`
+}
+
+func TestDecodeYAMLUnknownKeys(t *testing.T) {
+	testCases := []struct {
+		name          string
+		yaml          string
+		expectedError string
+	}{
+		{
+			name:          "no unknown keys",
+			yaml:          "name: example\nlogo:\n  path: /img/logo.png\n",
+			expectedError: "",
+		},
+		{
+			name:          "top-level key with different case",
+			yaml:          "NAME: example\n",
+			expectedError: "page.md:2: unknown key 'NAME' (did you mean 'name'?)",
+		},
+		{
+			name:          "key in nested struct",
+			yaml:          "name: example\nlogo:\n  path: /img/logo.png\n  alttext: Logo\n",
+			expectedError: "page.md:5: unknown key 'logo.alttext' (did you mean 'altText'?)",
+		},
+		{
+			name: "key in list item",
+			yaml: "name: example\nlinks:\n  - title: Docs\n    link: https://example.dev\n" +
+				"  - title: Code\n    lnk: https://example.dev/code\n",
+			expectedError: "page.md:7: unknown key 'links[1].lnk' (did you mean 'link'?)",
+		},
+		{
+			name:          "key in flow mapping",
+			yaml:          "name: example\nlogo: {path: /img/logo.png, alt: Logo}\n",
+			expectedError: "page.md:3: unknown key 'logo.alt'",
+		},
+		{
+			name:          "key without close match",
+			yaml:          "name: example\ndescription: Example\n",
+			expectedError: "page.md:3: unknown key 'description'",
+		},
+		{
+			name: "several unknown keys",
+			yaml: "nam: example\nlogo:\n  pth: /img/logo.png\n",
+			expectedError: "2 unknown keys" +
+				"\n- page.md:2: unknown key 'nam' (did you mean 'name'?)" +
+				"\n- page.md:4: unknown key 'logo.pth' (did you mean 'path'?)",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var dest testFrontmatter
+			// As in frontmatter, where the YAML starts after the opening delimiter
+			_, unknownKeys, err := decodeYAML("page.md", []byte(testCase.yaml), 2, &dest)
+			if err != nil {
+				t.Fatalf("unexpected decoding error: %v", err)
+			}
+
+			if testCase.expectedError == "" {
+				if unknownKeys != nil {
+					t.Errorf("unexpected unknown keys: %v", unknownKeys)
+				}
+			} else if unknownKeys == nil || unknownKeys.Error() != testCase.expectedError {
+				t.Errorf("expected error:\n%s\ngot:\n%v", testCase.expectedError, unknownKeys)
+			}
+		})
+	}
+}
+
+func TestReadMarkdownWithUnknownFrontmatterKeys(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "page.md")
+	content := "---\nname: example\ntagline: Example page\n---\n\nBody\n"
+	if err := os.WriteFile(filePath, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		mode          UnknownKeyMode
+		expectedError string
+	}{
+		{
+			mode: UnknownKeysError,
+			expectedError: "unknown keys in markdown frontmatter of '" + filePath + "'" +
+				"\n- " + filePath + ":3: unknown key 'tagline'",
+		},
+		{
+			mode:          UnknownKeysWarn,
+			expectedError: "",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(string(testCase.mode), func(t *testing.T) {
+			//nolint:exhaustruct
+			renderer := PageRenderer{
+				options: RenderOptions{UnknownFrontmatterKeys: testCase.mode},
+			}
+
+			var dest testFrontmatter
+			var body strings.Builder
+			_, err := renderer.readMarkdownWithFrontmatter(
+				context.Background(),
+				filePath,
+				&body,
+				&dest,
+				nil,
+			)
+
+			if testCase.expectedError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if dest.Name != "example" || !strings.Contains(body.String(), "<p>Body</p>") {
+					t.Errorf(
+						"expected page to be parsed, got name %q and body %q",
+						dest.Name,
+						body.String(),
+					)
+				}
+			} else if err == nil || err.Error() != testCase.expectedError {
+				t.Errorf("expected error:\n%s\ngot:\n%v", testCase.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestClosestKey(t *testing.T) {
+	validKeys := []string{"name", "tagLine", "logo", "links", "techStack"}
+
+	testCases := []struct {
+		key      string
+		expected string
+	}{
+		{key: "tagline", expected: "tagLine"},
+		{key: "nmae", expected: "name"},
+		{key: "link", expected: "links"},
+		{key: "tech", expected: ""},
+		{key: "techstak", expected: "techStack"},
+		{key: "description", expected: ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.key, func(t *testing.T) {
+			if closest := closestKey(testCase.key, validKeys); closest != testCase.expected {
+				t.Errorf("expected %q, got %q", testCase.expected, closest)
+			}
+		})
+	}
+
+	if closest := closestKey("name", nil); closest != "" {
+		t.Errorf("expected no suggestion without valid keys, got %q", closest)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	testCases := []struct {
+		string1  string
+		string2  string
+		expected int
+	}{
+		{string1: "", string2: "", expected: 0},
+		{string1: "name", string2: "", expected: 4},
+		{string1: "", string2: "name", expected: 4},
+		{string1: "name", string2: "name", expected: 0},
+		{string1: "name", string2: "nmae", expected: 2},
+		{string1: "tagline", string2: "tagLine", expected: 1},
+		{string1: "kitten", string2: "sitting", expected: 3},
+	}
+
+	for _, testCase := range testCases {
+		distance := editDistance(testCase.string1, testCase.string2)
+		if distance != testCase.expected {
+			t.Errorf(
+				"expected distance %d between %q and %q, got %d",
+				testCase.expected,
+				testCase.string1,
+				testCase.string2,
+				distance,
+			)
+		}
+	}
+}